@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+)
+
+// closingKeywords end blocks of keyword languages, lines starting with them
+// can't be headers of enclosing blocks.
+var closingKeywords = []string{"end", "fi", "done", "esac"}
+
+// ancestor is a candidate for ancestors of the following lines.
+type ancestor struct {
+	level int
+	line  BlockLine
+	// open is the number of parentheses and square brackets left open by
+	// the header, like by `func foo(` of a wrapped signature.
+	open int
+}
+
+// updateAncestors records the line as the last line of its level, given by
+// the block strategy. Comments and lines only opening or closing blocks are
+// skipped, lines closing brackets of the header above, like `) error {`,
+// continue that header.
+func updateAncestors(
+	ancestors *[]ancestor,
+	line string,
+	index int,
+	level int,
+	comments []string,
+) {
+	trimmed := strings.TrimLeft(line, " \t")
+	if trimmed == "" || hasAnyPrefix(trimmed, comments) {
+		return
+	}
+
+	stack := *ancestors
+	for len(stack) > 0 && stack[len(stack)-1].level > level {
+		stack = stack[:len(stack)-1]
+	}
+
+	if isContinuationLine(trimmed) && len(stack) > 0 {
+		header := &stack[len(stack)-1]
+		if header.level == level && header.open > 0 {
+			header.open += countOpenBrackets(trimmed)
+			*ancestors = stack
+			return
+		}
+	}
+
+	if isClosingLine(trimmed) || isOpeningLine(trimmed) {
+		return
+	}
+
+	for len(stack) > 0 && stack[len(stack)-1].level >= level {
+		stack = stack[:len(stack)-1]
+	}
+
+	*ancestors = append(stack, ancestor{
+		level: level,
+		line:  BlockLine{Line: index + 1, Text: line},
+		open:  countOpenBrackets(trimmed),
+	})
+}
+
+// getAncestors returns header lines of blocks enclosing the line with the
+// given level: each of them is the nearest line above the previous one with
+// a lower level. Levels of recorded lines grow, so these are all the lower
+// ones.
+func getAncestors(ancestors []ancestor, level int) []BlockLine {
+	result := []BlockLine{}
+	for _, ancestor := range ancestors {
+		if ancestor.level >= level {
+			break
+		}
+
+		result = append(result, ancestor.line)
+	}
+
+	return result
+}
+
+// isClosingLine reports whether the line only closes blocks, like `});` or
+// `end`.
+func isClosingLine(line string) bool {
+	if strings.HasPrefix(line, "</") {
+		return true
+	}
+
+	word := strings.TrimRight(strings.TrimSpace(line), ";,")
+	if isOneOf(strings.ToLower(word), closingKeywords) {
+		return true
+	}
+
+	return strings.Trim(word, "})] \t;,") == ""
+}
+
+// isOpeningLine reports whether the line only opens blocks, like `{` of the
+// Allman style, so the line above stays the header.
+func isOpeningLine(line string) bool {
+	return strings.Trim(line, "{[( \t") == ""
+}
+
+// isContinuationLine reports whether the line starts by closing a bracket,
+// like `) error {` or `):` ending a wrapped signature.
+func isContinuationLine(line string) bool {
+	return strings.HasPrefix(line, ")") || strings.HasPrefix(line, "]")
+}
+
+// countOpenBrackets returns the number of parentheses and square brackets
+// opened by the line minus the number of closed ones, brackets in strings
+// and comments are counted as well.
+func countOpenBrackets(line string) int {
+	open := 0
+	for _, char := range line {
+		switch char {
+		case '(', '[':
+			open++
+		case ')', ']':
+			open--
+		}
+	}
+
+	return open
+}
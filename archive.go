@@ -0,0 +1,341 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+)
+
+// archiveSeparator separates path of the archive from path of the file
+// inside of it in virtual paths like bundle.tar.gz:path/inside/file.yaml.
+const archiveSeparator = ":"
+
+// archiveBufferSize is the size of archive members which are read into
+// memory, so the walker can go on while they are searched. Larger members
+// are passed to the search as they are read from the archive.
+const archiveBufferSize = 1 << 20
+
+// File is a file found by FileWalker. Members of archives and contents of
+// compressed files are virtual files: their Path doesn't exist on disk and
+// their contents are returned by Open.
+type File struct {
+	Path string
+
+	// Open returns contents of virtual files, it's nil for files on disk.
+	// Open of a file passed directly from the archive must be called and the
+	// result closed exactly once, the walker waits for that.
+	Open func() (io.ReadCloser, error)
+
+	// Size is the size of contents of virtual files, -1 if it's unknown.
+	Size int64
+}
+
+// open returns contents of the file and its size, -1 if the size is unknown
+// like for pipes and decompressed streams.
+func (file File) open() (io.ReadCloser, int64, error) {
+	if file.Open != nil {
+		reader, err := file.Open()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return reader, file.Size, nil
+	}
+
+	reader, err := os.Open(file.Path)
+	if err != nil {
+		return nil, 0, karma.Format(err, "open file")
+	}
+
+	stat, err := reader.Stat()
+	if err != nil {
+		reader.Close()
+		return nil, 0, karma.Format(err, "stat file")
+	}
+
+	if !stat.Mode().IsRegular() {
+		return reader, -1, nil
+	}
+
+	return reader, stat.Size(), nil
+}
+
+// readCloser closes the decompressor and the underlying file.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (reader readCloser) Close() error {
+	return reader.close()
+}
+
+// decompressor wraps the reader into reader of decompressed contents, it
+// doesn't close the underlying reader.
+type decompressor func(reader io.Reader) (io.ReadCloser, error)
+
+func decompressGzip(reader io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(reader)
+}
+
+func decompressZstd(reader io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.IOReadCloser(), nil
+}
+
+var (
+	// compressions are extensions of single stream compressed files.
+	compressions = map[string]decompressor{
+		".gz":  decompressGzip,
+		".zst": decompressZstd,
+	}
+
+	// tarballs are extensions of compressed tar archives.
+	tarballs = map[string]decompressor{
+		".tgz":  decompressGzip,
+		".tzst": decompressZstd,
+	}
+)
+
+// isCompressed reports whether the file is searched as an archive or
+// a compressed file in the --search-zip mode.
+func isCompressed(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	_, compressed := compressions[ext]
+	_, tarball := tarballs[ext]
+
+	return compressed || tarball || ext == ".tar" || ext == ".zip"
+}
+
+// walkCompressed calls process for every member of the archive or for
+// decompressed contents of the compressed file, members are skipped unless
+// they have one of the extensions.
+func walkCompressed(
+	path string,
+	extensions []string,
+	process func(file File) error,
+) error {
+	var (
+		lower = strings.ToLower(path)
+		ext   = filepath.Ext(lower)
+	)
+
+	switch {
+	case ext == ".zip":
+		return walkZip(path, extensions, process)
+
+	case ext == ".tar":
+		return walkTar(path, nil, extensions, process)
+
+	case tarballs[ext] != nil:
+		return walkTar(path, tarballs[ext], extensions, process)
+
+	case strings.HasSuffix(strings.TrimSuffix(lower, ext), ".tar"):
+		return walkTar(path, compressions[ext], extensions, process)
+	}
+
+	// the virtual file is named like the compressed one without the
+	// extension, so the language is detected by the original extension
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if len(extensions) != 0 && !hasExtension(name, extensions) {
+		return nil
+	}
+
+	decompress := compressions[ext]
+
+	return process(File{
+		Path: path + archiveSeparator + name,
+		Size: -1,
+		Open: func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, karma.Format(err, "open file")
+			}
+
+			reader, err := decompress(file)
+			if err != nil {
+				file.Close()
+				return nil, karma.Format(err, "decompress %s", path)
+			}
+
+			return readCloser{
+				Reader: reader,
+				close: func() error {
+					reader.Close()
+					return file.Close()
+				},
+			}, nil
+		},
+	})
+}
+
+// walkTar reads members of the tar archive compressed by the decompressor
+// if it's not nil.
+func walkTar(
+	path string,
+	decompress decompressor,
+	extensions []string,
+	process func(file File) error,
+) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return karma.Format(err, "open archive")
+	}
+
+	defer file.Close()
+
+	var input io.Reader = file
+	if decompress != nil {
+		reader, err := decompress(file)
+		if err != nil {
+			return karma.Format(err, "decompress %s", path)
+		}
+
+		defer reader.Close()
+
+		input = reader
+	}
+
+	archive := tar.NewReader(input)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return karma.Format(err, "read archive %s", path)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if len(extensions) != 0 && !hasExtension(header.Name, extensions) {
+			continue
+		}
+
+		err = processMember(
+			getMemberPath(path, header.Name),
+			header.Size,
+			archive,
+			process,
+		)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkZip reads members of the zip archive.
+func walkZip(
+	path string,
+	extensions []string,
+	process func(file File) error,
+) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return karma.Format(err, "open archive")
+	}
+
+	defer archive.Close()
+
+	for _, member := range archive.File {
+		if !member.Mode().IsRegular() {
+			continue
+		}
+
+		if len(extensions) != 0 && !hasExtension(member.Name, extensions) {
+			continue
+		}
+
+		reader, err := member.Open()
+		if err != nil {
+			return karma.Format(err, "read archive %s", path)
+		}
+
+		err = processMember(
+			getMemberPath(path, member.Name),
+			int64(member.UncompressedSize64),
+			reader,
+			process,
+		)
+
+		reader.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getMemberPath returns the virtual path of the member of the archive.
+func getMemberPath(archive string, name string) string {
+	return archive + archiveSeparator + strings.TrimPrefix(name, "./")
+}
+
+// processMember passes the virtual file read from the archive or from the
+// git repository to process, small files are read into memory, while the
+// reader of large ones is passed directly and it's waited until they are
+// searched.
+func processMember(
+	path string,
+	size int64,
+	reader io.Reader,
+	process func(file File) error,
+) error {
+	if size <= archiveBufferSize {
+		contents, err := io.ReadAll(reader)
+		if err != nil {
+			return karma.Format(err, "read %s", path)
+		}
+
+		return process(File{
+			Path: path,
+			Size: int64(len(contents)),
+			Open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(contents)), nil
+			},
+		})
+	}
+
+	log.Debugf(nil, "%s is searched while it's read", path)
+
+	done := make(chan struct{})
+	err := process(File{
+		Path: path,
+		Size: size,
+		Open: func() (io.ReadCloser, error) {
+			return readCloser{
+				Reader: reader,
+				close: func() error {
+					close(done)
+					return nil
+				},
+			}, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	<-done
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+type testArchiveMember struct {
+	name     string
+	contents string
+}
+
+func writeTestTar(t *testing.T, path string, members []testArchiveMember) {
+	var buffer bytes.Buffer
+
+	archive := tar.NewWriter(&buffer)
+	for _, member := range members {
+		err := archive.WriteHeader(&tar.Header{
+			Name:     member.name,
+			Mode:     0644,
+			Size:     int64(len(member.contents)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = archive.Write([]byte(member.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := buffer.Bytes()
+	if strings.HasSuffix(path, ".gz") {
+		contents = gzipTestContents(t, contents)
+	}
+
+	err = os.WriteFile(path, contents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, path string, members []testArchiveMember) {
+	var buffer bytes.Buffer
+
+	archive := zip.NewWriter(&buffer)
+	for _, member := range members {
+		writer, err := archive.Create(member.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = writer.Write([]byte(member.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, buffer.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipTestContents(t *testing.T, contents []byte) []byte {
+	var buffer bytes.Buffer
+
+	writer := gzip.NewWriter(&buffer)
+
+	_, err := writer.Write(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestFileWalker_SearchZip(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	members := []testArchiveMember{
+		{"./a/config.yaml", "a:\n  b: foo\n"},
+		{"main.go", "func foo() {\n}\n"},
+		{"large.txt", strings.Repeat("line\n", archiveBufferSize/5) + "foo\n"},
+	}
+
+	writeTestTar(t, filepath.Join(dir, "bundle.tar.gz"), members)
+	writeTestTar(t, filepath.Join(dir, "bundle.tar"), members[:1])
+	writeTestZip(t, filepath.Join(dir, "bundle.zip"), members)
+
+	compressed := gzipTestContents(t, []byte("func foo() {\n}\n"))
+	test.NoError(os.WriteFile(filepath.Join(dir, "main.go.gz"), compressed, 0644))
+
+	encoder, err := zstd.NewWriter(nil)
+	test.NoError(err)
+	test.NoError(os.WriteFile(
+		filepath.Join(dir, "app.log.zst"),
+		encoder.EncodeAll([]byte("foo\n  bar\n"), nil),
+		0644,
+	))
+
+	walker := NewFileWalker(nil)
+	walker.SearchZip = true
+
+	found := map[string]Blocks{}
+	searchFiles(
+		walker,
+		[]string{dir},
+		2,
+		func(file File) searchResult {
+			blocks, err := findFileBlocks(file, regexp.MustCompile(`foo`), BlockOptions{})
+			return searchResult{Path: file.Path, Blocks: blocks, Err: err}
+		},
+		func(result searchResult) bool {
+			test.NoError(result.Err, result.Path)
+
+			path, _ := filepath.Rel(dir, result.Path)
+			found[path] = result.Blocks
+
+			return true
+		},
+	)
+
+	test.Equal(
+		map[string][][2]int{
+			"app.log.zst:app.log":         {{1, 3}},
+			"bundle.tar.gz:a/config.yaml": {{2, 3}},
+			"bundle.tar.gz:large.txt":     {{archiveBufferSize/5 + 1, archiveBufferSize/5 + 2}},
+			"bundle.tar.gz:main.go":       {{1, 2}},
+			"bundle.tar:a/config.yaml":    {{2, 3}},
+			"bundle.zip:a/config.yaml":    {{2, 3}},
+			"bundle.zip:large.txt":        {{archiveBufferSize/5 + 1, archiveBufferSize/5 + 2}},
+			"bundle.zip:main.go":          {{1, 2}},
+			"main.go.gz:main.go":          {{1, 2}},
+		},
+		getFoundLineRanges(found),
+	)
+
+	walker = NewFileWalker([]string{"go"})
+	walker.SearchZip = true
+
+	files := []string{}
+	err = walker.WalkFiles(dir, func(file File) error {
+		path, _ := filepath.Rel(dir, file.Path)
+		files = append(files, path)
+
+		reader, _, err := file.open()
+		if err == nil {
+			reader.Close()
+		}
+
+		return err
+	})
+	test.NoError(err)
+	test.Equal(
+		[]string{"bundle.tar.gz:main.go", "bundle.zip:main.go", "main.go.gz:main.go"},
+		files,
+	)
+}
+
+func getFoundLineRanges(found map[string]Blocks) map[string][][2]int {
+	result := map[string][][2]int{}
+	for path, blocks := range found {
+		result[path] = getLineRanges(blocks)
+	}
+
+	return result
+}
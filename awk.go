@@ -3,26 +3,148 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/benhoyt/goawk/interp"
+	"github.com/benhoyt/goawk/parser"
+	"github.com/reconquest/karma-go"
 )
 
+// AwkMode defines how results of several AWK conditions are combined.
+type AwkMode string
+
+const (
+	// AwkAny keeps blocks matching at least one of the conditions.
+	AwkAny AwkMode = "any"
+
+	// AwkAll keeps blocks matching every condition.
+	AwkAll AwkMode = "all"
+
+	// AwkNone keeps blocks matching none of the conditions.
+	AwkNone AwkMode = "none"
+)
+
+// parseAwkMode parses mode name, empty name means AwkAny.
+func parseAwkMode(value string) (AwkMode, error) {
+	switch mode := AwkMode(value); mode {
+	case "":
+		return AwkAny, nil
+	case AwkAny, AwkAll, AwkNone:
+		return mode, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown awk mode: %q, expected any, all or none",
+			value,
+		)
+	}
+}
+
+// awkProgram is a parsed AWK program which can be executed many times.
+type awkProgram struct {
+	program *parser.Program
+	environ []string
+
+	// interpreters keeps reusable interpreters of the program, an
+	// interpreter can't be executed concurrently
+	interpreters sync.Pool
+}
+
+func compileAwkProgram(contents string) (*awkProgram, error) {
+	program, err := parser.ParseProgram([]byte(contents), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &awkProgram{
+		program: program,
+		environ: getAwkEnviron(),
+	}, nil
+}
+
+// getAwkEnviron returns the environment as name, value pairs for ENVIRON,
+// building it once avoids reading it on every execution.
+func getAwkEnviron() []string {
+	environ := []string{}
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		environ = append(environ, name, value)
+	}
+
+	return environ
+}
+
+func (program *awkProgram) getInterpreter() (*interp.Interpreter, error) {
+	if interpreter, ok := program.interpreters.Get().(*interp.Interpreter); ok {
+		// variables are kept between executions
+		interpreter.ResetVars()
+		return interpreter, nil
+	}
+
+	return interp.New(program.program)
+}
+
+// execute runs the program over the input and returns what it printed, vars
+// are name, value pairs of variables to set before the execution.
+func (program *awkProgram) execute(input string, vars []string) (string, error) {
+	interpreter, err := program.getInterpreter()
+	if err != nil {
+		return "", err
+	}
+
+	defer program.interpreters.Put(interpreter)
+
+	output := bytes.NewBuffer(nil)
+
+	_, err = interpreter.Execute(&interp.Config{
+		Stdin:   strings.NewReader(input),
+		Output:  output,
+		Environ: program.environ,
+		Vars:    vars,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return output.String(), nil
+}
+
+// getAwkVars returns metadata of the block as name, value pairs of AWK
+// variables available in conditions and print programs.
+func getAwkVars(filename string, lang string, block Block) []string {
+	match := ""
+	if len(block.Matches) > 0 {
+		match = block.Matches[0].Text
+	}
+
+	return []string{
+		// FILENAME is reset by the interpreter when reading input, so it's
+		// assigned from _filename after a line of the block is read
+		"_filename", filename,
+		"LINE_START", strconv.Itoa(block.GetLineStart()),
+		"LINE_END", strconv.Itoa(block.GetLineEnd()),
+		"NLINES", strconv.Itoa(len(block.Lines)),
+		"DEPTH", strconv.Itoa(len(block.Ancestors)),
+		"MATCH", match,
+		"LANG", lang,
+	}
+}
+
 type AwkwardMatcher struct {
 	Condition string
-	contents  string
+	program   *awkProgram
 }
 
-func NewAwkwardMatcher(condition string) *AwkwardMatcher {
+// NewAwkwardMatcher parses the condition once, so syntax errors are reported
+// before any block is matched.
+func NewAwkwardMatcher(condition string) (*AwkwardMatcher, error) {
 	if condition == "" {
 		condition = "1"
 	}
 
-	matcher := &AwkwardMatcher{
-		Condition: condition,
-	}
-
-	matcher.contents = `
+	contents := `
 	{
 		_line = $0
 		if (_block) {
@@ -35,6 +157,7 @@ func NewAwkwardMatcher(condition string) *AwkwardMatcher {
 		_matched = 0
 
 		$0 = _block
+		FILENAME = _filename
 		if (` + condition + `) {
 			_matched = 1
 		}
@@ -46,19 +169,28 @@ func NewAwkwardMatcher(condition string) *AwkwardMatcher {
 		}
 	}`
 
-	return matcher
-}
+	program, err := compileAwkProgram(contents)
+	if err != nil {
+		return nil, karma.
+			Describe("condition", condition).
+			Format(err, "parse awk condition")
+	}
 
-func (matcher *AwkwardMatcher) Match(block string) (bool, error) {
-	input := strings.NewReader(block)
-	output := bytes.NewBuffer(nil)
+	return &AwkwardMatcher{
+		Condition: condition,
+		program:   program,
+	}, nil
+}
 
-	err := interp.Exec(matcher.contents, " ", input, output)
+// Match evaluates the condition against the block text, vars are name, value
+// pairs of variables to set before the execution.
+func (matcher *AwkwardMatcher) Match(block string, vars []string) (bool, error) {
+	output, err := matcher.program.execute(block, vars)
 	if err != nil {
 		return false, err
 	}
 
-	result := strings.TrimSpace(output.String())
+	result := strings.TrimSpace(output)
 
 	switch result {
 	case "TRUE":
@@ -69,3 +201,38 @@ func (matcher *AwkwardMatcher) Match(block string) (bool, error) {
 		return false, fmt.Errorf("unexpected result: %s", result)
 	}
 }
+
+// AwkwardPrinter runs an AWK program over lines of the block, what the
+// program prints replaces the block in the output.
+type AwkwardPrinter struct {
+	Program string
+	program *awkProgram
+}
+
+// NewAwkwardPrinter parses the program once, so syntax errors are reported
+// before any block is printed.
+func NewAwkwardPrinter(contents string) (*AwkwardPrinter, error) {
+	// the rule goes on the same line to keep positions of syntax errors
+	program, err := compileAwkProgram(`{ FILENAME = _filename } ` + contents)
+	if err != nil {
+		return nil, karma.
+			Describe("program", contents).
+			Format(err, "parse awk program")
+	}
+
+	return &AwkwardPrinter{
+		Program: contents,
+		program: program,
+	}, nil
+}
+
+// Print runs the program against the block text and returns its output
+// without the trailing newline.
+func (printer *AwkwardPrinter) Print(block string, vars []string) (string, error) {
+	output, err := printer.program.execute(block, vars)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(output, "\n"), nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,7 +10,8 @@ import (
 func TestAwkTest(t *testing.T) {
 	test := assert.New(t)
 
-	matcher := NewAwkwardMatcher("/a/")
+	matcher, err := NewAwkwardMatcher("/a/")
+	test.NoError(err)
 
 	testcases := []struct {
 		block    string
@@ -29,7 +31,7 @@ func TestAwkTest(t *testing.T) {
 	}
 
 	for i, testcase := range testcases {
-		actual, err := matcher.Match(testcase.block)
+		actual, err := matcher.Match(testcase.block, nil)
 		if testcase.err {
 			test.Error(err, "testcase %d", i)
 		} else {
@@ -39,3 +41,65 @@ func TestAwkTest(t *testing.T) {
 		test.Equal(testcase.expected, actual, "testcase %d", i)
 	}
 }
+
+func TestAwkSyntaxError(t *testing.T) {
+	test := assert.New(t)
+
+	_, err := NewAwkwardMatcher("/a/ &&")
+	test.Error(err)
+}
+
+func BenchmarkAwkwardMatcher(b *testing.B) {
+	matcher, err := NewAwkwardMatcher(`/func/ && NF > 2`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	block := strings.Repeat("func main() {\n\tprintln(1)\n}\n", 10)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := matcher.Match(block, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAwkVars(t *testing.T) {
+	test := assert.New(t)
+
+	block := Block{
+		Lines: []BlockLine{
+			{Line: 3, Text: "func main() {"},
+			{Line: 4, Text: "\tpanic(1)"},
+			{Line: 5, Text: "}"},
+		},
+		Ancestors: []BlockLine{{Line: 1, Text: "package main"}},
+		Matches:   []BlockMatch{{Line: 3, Text: "main"}},
+	}
+
+	vars := getAwkVars("cmd/main_test.go", "Go", block)
+
+	testcases := []struct {
+		condition string
+		expected  bool
+	}{
+		{`FILENAME ~ /_test\.go$/`, true},
+		{`LINE_START == 3 && LINE_END == 5`, true},
+		{`NLINES > 2`, true},
+		{`NLINES > 80`, false},
+		{`DEPTH == 1`, true},
+		{`MATCH == "main"`, true},
+		{`LANG == "Go"`, true},
+	}
+
+	for _, testcase := range testcases {
+		matcher, err := NewAwkwardMatcher(testcase.condition)
+		test.NoError(err)
+
+		actual, err := matcher.Match(block.JoinLines(), vars)
+		test.NoError(err)
+		test.Equal(testcase.expected, actual, testcase.condition)
+	}
+}
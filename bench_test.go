@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// writeBenchCorpus generates Go-like files, only every tenth of them
+// contains the rare statement.
+func writeBenchCorpus(b *testing.B) []string {
+	b.Helper()
+
+	dir := b.TempDir()
+
+	files := []string{}
+	for i := 0; i < 100; i++ {
+		var contents strings.Builder
+		contents.WriteString("package corpus\n\n")
+
+		for j := 0; j < 50; j++ {
+			fmt.Fprintf(&contents, "func Handler%d(ctx context.Context) error {\n", j)
+			fmt.Fprintf(&contents, "\tvalue := compute(%d)\n", j)
+			contents.WriteString("\tif value > 0 {\n\t\treturn nil\n\t}\n")
+			if i%10 == 0 && j == 25 {
+				contents.WriteString("\tpanic(\"unreachable\")\n")
+			}
+			contents.WriteString("\treturn errors.New(\"failed\")\n}\n\n")
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("file%d.go", i))
+		err := os.WriteFile(path, []byte(contents.String()), 0644)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		files = append(files, path)
+	}
+
+	return files
+}
+
+func BenchmarkFindBlocks(b *testing.B) {
+	files := writeBenchCorpus(b)
+
+	queries := map[string]string{
+		"rare literal":   `panic\("unreachable"\)`,
+		"common literal": `^func Handler1\d\(`,
+		"no literal":     `(?i)PANIC`,
+	}
+
+	for name, query := range queries {
+		query := regexp.MustCompile(query)
+
+		for _, prefilter := range []bool{true, false} {
+			options := BlockOptions{}
+			if prefilter {
+				options.Literal = getRequiredLiteral(query)
+			}
+
+			b.Run(fmt.Sprintf("%s/prefilter=%v", name, prefilter), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					for _, file := range files {
+						_, err := findBlocks(file, query, options)
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkFindBlocks_Ancestors searches every line of a nested file, time
+// per line must not grow with the size of the file.
+func BenchmarkFindBlocks_Ancestors(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		var contents strings.Builder
+		contents.WriteString("root:\n")
+		for i := 0; i < size; i++ {
+			fmt.Fprintf(&contents, "  item%d: x\n", i)
+		}
+
+		path := filepath.Join(b.TempDir(), "items.yaml")
+		err := os.WriteFile(path, []byte(contents.String()), 0644)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		query := regexp.MustCompile(`item`)
+
+		b.Run(fmt.Sprintf("lines=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := findBlocks(path, query, BlockOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
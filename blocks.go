@@ -3,44 +3,265 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
+	"math"
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/alecthomas/chroma"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/quick"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/pkg/log"
+	"golang.org/x/text/encoding"
 )
 
 type BlockLine struct {
-	Line int
-	Text string
+	Line int    `json:"line"`
+	Text string `json:"text"`
 }
 
-type Block []BlockLine
+// BlockMatch describes a match of the query in the block.
+type BlockMatch struct {
+	Line int `json:"line"`
+
+	// LineEnd is the last line of the match, it differs from Line only for
+	// multiline matches.
+	LineEnd int `json:"line_end"`
+
+	// Start is byte offset of the match in the first line, End is byte
+	// offset of the match end in the last line.
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	// Column is the number of the first character of the match in the line
+	// starting from 1.
+	Column int `json:"column"`
+
+	Text       string     `json:"text"`
+	Submatches []Submatch `json:"submatches"`
+}
+
+// Submatch describes a capturing group of the query, Start and End are -1
+// if the group didn't participate in the match.
+type Submatch struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// findLineMatches returns all matches of the query in the given line.
+func findLineMatches(query *regexp.Regexp, text string, line int) []BlockMatch {
+	if !query.MatchString(text) {
+		return nil
+	}
+
+	matches := []BlockMatch{}
+	for _, indexes := range query.FindAllStringSubmatchIndex(text, -1) {
+		match := newBlockMatch(query, text, indexes, 0)
+		match.Line = line
+		match.LineEnd = line
+
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// findMultilineMatches runs the query against the whole contents and returns
+// function that gives matches starting at the line with given index.
+func findMultilineMatches(
+	query *regexp.Regexp,
+	contents string,
+	lines []string,
+) func(index int) []BlockMatch {
+	offsets := make([]int, len(lines)+1)
+	for index, line := range lines {
+		offsets[index+1] = offsets[index] + len(line) + 1
+	}
+
+	// getLine returns index of the line containing given byte offset
+	getLine := func(offset int) int {
+		return sort.Search(len(lines), func(index int) bool {
+			return offsets[index+1] > offset
+		})
+	}
+
+	byLine := map[int][]BlockMatch{}
+	for _, indexes := range query.FindAllStringSubmatchIndex(contents, -1) {
+		first := getLine(indexes[0])
+
+		last := first
+		if indexes[1] > indexes[0] {
+			last = getLine(indexes[1] - 1)
+		}
+
+		match := newBlockMatch(query, contents, indexes, offsets[first])
+		match.Line = first + 1
+		match.LineEnd = last + 1
+		match.End = indexes[1] - offsets[last]
+
+		byLine[first] = append(byLine[first], match)
+	}
+
+	return func(index int) []BlockMatch {
+		return byLine[index]
+	}
+}
+
+// newBlockMatch creates match from submatch indexes in the given text,
+// offsets are made relative to the given base.
+func newBlockMatch(
+	query *regexp.Regexp,
+	text string,
+	indexes []int,
+	base int,
+) BlockMatch {
+	names := query.SubexpNames()
+
+	match := BlockMatch{
+		Start:      indexes[0] - base,
+		End:        indexes[1] - base,
+		Column:     utf8.RuneCountInString(text[base:indexes[0]]) + 1,
+		Text:       text[indexes[0]:indexes[1]],
+		Submatches: []Submatch{},
+	}
+
+	for group := 1; group < len(names); group++ {
+		submatch := Submatch{
+			Index: group,
+			Name:  names[group],
+			Start: indexes[group*2],
+			End:   indexes[group*2+1],
+		}
+
+		if submatch.Start >= 0 {
+			submatch.Text = text[submatch.Start:submatch.End]
+			submatch.Start -= base
+			submatch.End -= base
+		}
+
+		match.Submatches = append(match.Submatches, submatch)
+	}
+
+	return match
+}
+
+// getMatchesEnd returns index of the last line covered by the matches.
+func getMatchesEnd(matches []BlockMatch) int {
+	end := 0
+	for _, match := range matches {
+		if match.LineEnd-1 > end {
+			end = match.LineEnd - 1
+		}
+	}
+
+	return end
+}
+
+type Block struct {
+	Lines []BlockLine
+
+	// Ancestors are header lines of blocks enclosing the matching line,
+	// the outermost goes first.
+	Ancestors []BlockLine
+
+	// Matches lists lines of the block matching the query.
+	Matches []BlockMatch
+
+	// Output is what the --awk-print program printed for the block, it
+	// replaces lines of the block in the output.
+	Output string
+
+	// Truncated is the number of lines dropped from the end of the block
+	// because of BlockOptions.MaxBlockLines.
+	Truncated int
+}
+
+// newBlock creates block of the lines from begin to end indexes inclusive.
+func newBlock(lines []string, begin int, end int) Block {
+	block := Block{
+		Lines: make([]BlockLine, 0, end-begin+1),
+	}
+
+	for index := begin; index <= end; index++ {
+		block.Lines = append(block.Lines, BlockLine{
+			Line: index + 1,
+			Text: lines[index],
+		})
+	}
+
+	return block
+}
+
+// extend appends lines up to the given end index to the block.
+func (block *Block) extend(lines []string, end int) {
+	for index := block.GetLineEnd(); index <= end; index++ {
+		block.Lines = append(block.Lines, BlockLine{
+			Line: index + 1,
+			Text: lines[index],
+		})
+	}
+}
+
+// truncate drops lines after the given number of lines along with matches
+// starting in them.
+func (block *Block) truncate(max int) {
+	if max <= 0 || len(block.Lines) <= max {
+		return
+	}
+
+	block.Truncated += len(block.Lines) - max
+	block.Lines = block.Lines[:max]
+
+	end := block.GetLineEnd()
+
+	matches := make([]BlockMatch, 0, len(block.Matches))
+	for _, match := range block.Matches {
+		if match.Line <= end {
+			matches = append(matches, match)
+		}
+	}
+
+	block.Matches = matches
+}
 
 func (block Block) GetLineStart() int {
-	return block[0].Line
+	return block.Lines[0].Line
 }
 
 func (block Block) GetLineEnd() int {
-	return block[len(block)-1].Line
+	return block.Lines[len(block.Lines)-1].Line
 }
 
 func (block Block) JoinLines() string {
-	lines := make([]string, len(block))
-	for i := 0; i < len(block); i++ {
-		lines[i] = block[i].Text
+	lines := make([]string, len(block.Lines))
+	for i := 0; i < len(block.Lines); i++ {
+		lines[i] = block.Lines[i].Text
 	}
 	return strings.Join(lines, "\n")
 }
 
+// FormatAncestors returns breadcrumb of the enclosing blocks, like
+// `class Server: > def run(self):`.
+func (block Block) FormatAncestors() string {
+	headers := make([]string, len(block.Ancestors))
+	for i, ancestor := range block.Ancestors {
+		headers[i] = strings.TrimSpace(ancestor.Text)
+	}
+
+	return strings.Join(headers, " > ")
+}
+
 func (block Block) Format(
 	showFilenameInline bool,
 	filename string,
@@ -48,33 +269,30 @@ func (block Block) Format(
 	useColors bool,
 ) string {
 	if !useColors {
-		lines := make([]string, len(block))
-		for i := 0; i < len(block); i++ {
+		lines := make([]string, len(block.Lines))
+		for i := 0; i < len(block.Lines); i++ {
 			lines[i] = formatLine(
 				showFilenameInline,
 				filename,
 				showLine,
-				block[i].Line,
-				block[i].Text,
+				block.Lines[i].Line,
+				block.Lines[i].Text,
 			)
 		}
 
 		return strings.Join(lines, "\n")
 	}
 
-	lines := make([]string, len(block))
-	numbers := make([]int, len(block))
-	for i := 0; i < len(block); i++ {
-		lines[i] = block[i].Text
-		numbers[i] = block[i].Line
+	lines := make([]string, len(block.Lines))
+	numbers := make([]int, len(block.Lines))
+	for i := 0; i < len(block.Lines); i++ {
+		lines[i] = block.Lines[i].Text
+		numbers[i] = block.Lines[i].Line
 	}
 
 	buffer := bytes.NewBuffer(nil)
 
-	lexer := lexers.Match(filename)
-	if lexer == nil {
-		lexer = lexers.Fallback
-	}
+	lexer := getLexer(filename)
 
 	err := quick.Highlight(
 		buffer,
@@ -87,31 +305,144 @@ func (block Block) Format(
 		log.Errorf(err, "syntax highlight: %q %v", filename, numbers)
 	}
 
-	if !showLine && !showFilenameInline {
-		return buffer.String()
-	}
-
 	highlighted := strings.Split(buffer.String(), "\n")
 
+	spans := map[int][][2]int{}
+	for _, match := range block.Matches {
+		if match.LineEnd == match.Line {
+			if match.End > match.Start {
+				spans[match.Line] = append(
+					spans[match.Line],
+					[2]int{match.Start, match.End},
+				)
+			}
+
+			continue
+		}
+
+		for line := match.Line; line <= match.LineEnd; line++ {
+			span := [2]int{0, math.MaxInt}
+			if line == match.Line {
+				span[0] = match.Start
+			}
+
+			if line == match.LineEnd {
+				span[1] = match.End
+			}
+
+			spans[line] = append(spans[line], span)
+		}
+	}
+
 	min := len(highlighted)
-	if len(block) < min {
-		min = len(block)
+	if len(block.Lines) < min {
+		min = len(block.Lines)
 	}
 	for i := 0; i < min; i++ {
-		highlighted[i] = formatLine(
-			showFilenameInline,
-			filename,
-			showLine,
-			block[i].Line,
+		highlighted[i] = highlightSpans(
 			highlighted[i],
+			spans[block.Lines[i].Line],
 		)
+
+		if showLine || showFilenameInline {
+			highlighted[i] = formatLine(
+				showFilenameInline,
+				filename,
+				showLine,
+				block.Lines[i].Line,
+				highlighted[i],
+			)
+		}
 	}
 
 	return strings.Join(highlighted, "\n")
 }
 
+const (
+	ansiHighlightStart = "\x1b[7m"
+	ansiHighlightEnd   = "\x1b[27m"
+)
+
+// highlightSpans inverts colors of the given byte spans of the original line
+// in the line already highlighted by chroma. Escape sequences don't count as
+// line bytes, highlighting is applied again after each of them because they
+// may reset it.
+func highlightSpans(line string, spans [][2]int) string {
+	if len(spans) == 0 {
+		return line
+	}
+
+	var (
+		result = strings.Builder{}
+		offset = 0
+		span   = 0
+		inside = false
+	)
+
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			end := i + 1
+			for end < len(line) && !isASCIILetter(line[end]) {
+				end++
+			}
+
+			if end < len(line) {
+				end++
+			}
+
+			result.WriteString(line[i:end])
+			if inside {
+				result.WriteString(ansiHighlightStart)
+			}
+
+			i = end
+			continue
+		}
+
+		for span < len(spans) && inside && offset >= spans[span][1] {
+			result.WriteString(ansiHighlightEnd)
+			inside = false
+			span++
+		}
+
+		if span < len(spans) && !inside && offset >= spans[span][0] {
+			result.WriteString(ansiHighlightStart)
+			inside = true
+		}
+
+		result.WriteByte(line[i])
+		offset++
+		i++
+	}
+
+	if inside {
+		result.WriteString(ansiHighlightEnd)
+	}
+
+	return result.String()
+}
+
+func isASCIILetter(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
 type Blocks []Block
 
+// FormatOutput returns output of the --awk-print program, lines are prefixed
+// with the filename if it's shown inline.
+func (block Block) FormatOutput(showFilenameInline bool, filename string) string {
+	if !showFilenameInline {
+		return block.Output
+	}
+
+	lines := strings.Split(block.Output, "\n")
+	for i := range lines {
+		lines[i] = filename + ":" + lines[i]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func (blocks Blocks) Format(
 	showFilenameInline bool,
 	filename string,
@@ -120,12 +451,35 @@ func (blocks Blocks) Format(
 ) []string {
 	result := make([]string, len(blocks))
 	for i := 0; i < len(blocks); i++ {
-		block := blocks[i].Format(
-			showFilenameInline,
-			filename,
-			showLine,
-			useColors,
-		)
+		var block string
+		if blocks[i].Output != "" {
+			block = blocks[i].FormatOutput(showFilenameInline, filename)
+		} else {
+			block = blocks[i].Format(
+				showFilenameInline,
+				filename,
+				showLine,
+				useColors,
+			)
+
+			// the breadcrumb is marked, so it can't be taken for a line of
+			// the block
+			if len(blocks[i].Ancestors) > 0 {
+				ancestors := "» " + blocks[i].FormatAncestors()
+				if showFilenameInline {
+					ancestors = filename + ":" + ancestors
+				}
+
+				block = ancestors + "\n" + block
+			}
+
+			if blocks[i].Truncated > 0 {
+				block += fmt.Sprintf(
+					"\n... truncated, %d more lines",
+					blocks[i].Truncated,
+				)
+			}
+		}
 
 		if !showFilenameInline {
 			result[i] = filename + "\n" + block
@@ -138,10 +492,14 @@ func (blocks Blocks) Format(
 }
 
 type BlockExport struct {
-	Filename  string `json:"filename"`
-	LineStart int    `json:"line_start"`
-	LineEnd   int    `json:"line_end"`
-	Text      string `json:"text"`
+	Filename  string       `json:"filename"`
+	LineStart int          `json:"line_start"`
+	LineEnd   int          `json:"line_end"`
+	Text      string       `json:"text"`
+	Ancestors []BlockLine  `json:"ancestors"`
+	Matches   []BlockMatch `json:"matches"`
+	Output    string       `json:"output,omitempty"`
+	Truncated bool         `json:"truncated"`
 }
 
 func (blocks *Blocks) EncodeJSON(
@@ -185,133 +543,545 @@ func (blocks *Blocks) Stream(streamCmd string, filename string) error {
 }
 
 func (block Block) EncodeJSON(filename string) ([]byte, error) {
+	return json.Marshal(block.export(filename))
+}
+
+// export returns the block as it's encoded in JSON.
+func (block Block) export(filename string) BlockExport {
 	export := BlockExport{
 		Filename:  filename,
 		LineStart: block.GetLineStart(),
 		LineEnd:   block.GetLineEnd(),
 		Text:      block.JoinLines(),
+		Ancestors: block.Ancestors,
+		Matches:   block.Matches,
+		Output:    block.Output,
+		Truncated: block.Truncated > 0,
+	}
+
+	if export.Ancestors == nil {
+		export.Ancestors = []BlockLine{}
+	}
+
+	return export
+}
+
+// BlockOptions configures how blocks are extracted from the file.
+type BlockOptions struct {
+	// HigherThan is the indentation offset of the indent strategy.
+	HigherThan int
+
+	// Strategy is the name of block strategy, empty or "auto" picks the
+	// strategy by file extension.
+	Strategy string
+
+	// TabWidth overrides tab width of all files when positive.
+	TabWidth int
+
+	// DefaultTabWidth is used when tab width is not specified neither via
+	// TabWidth nor via .editorconfig.
+	DefaultTabWidth int
+
+	// IncludeDocs attaches comments, decorators and annotations placed right
+	// above the matching line to the block.
+	IncludeDocs bool
+
+	// Up is the number of levels of the block strategy to climb from the
+	// matching line to the enclosing block which is returned instead,
+	// negative value climbs to the top level.
+	Up int
+
+	// Overlap is the policy for matches inside of already found blocks.
+	Overlap OverlapPolicy
+
+	// Multiline runs the query against the whole file contents instead of
+	// separate lines, the block is extended to cover the entire match.
+	Multiline bool
+
+	// Contains lists patterns which all must match the block text.
+	Contains []*regexp.Regexp
+
+	// NotContains lists patterns which all must not match the block text.
+	NotContains []*regexp.Regexp
+
+	// MaxLineLength limits length of lines in huge files which are read
+	// line by line, defaultMaxLineLength is used if it's not positive.
+	MaxLineLength int
+
+	// MaxFileSize skips files larger than the given number of bytes when
+	// positive.
+	MaxFileSize int64
+
+	// MaxBlockLines truncates blocks to the given number of lines when
+	// positive, the limit applies after Contains and NotContains.
+	MaxBlockLines int
+
+	// Encoding decodes files which are not valid UTF-8, such files are
+	// searched as is if it's nil.
+	Encoding encoding.Encoding
+
+	// SkipTerminatingLine ends blocks of the indent strategy before the line
+	// at the base level which terminates them unless it only closes them,
+	// like `}`, so the next statement may start a block of its own.
+	SkipTerminatingLine bool
+
+	// Literal is the literal required by the query, files and lines without
+	// it are skipped without running the query. It's computed once per
+	// search by getRequiredLiteral, empty disables the prefilter.
+	Literal string
+}
+
+// compileContentPatterns compiles patterns of Contains and NotContains, ^ and
+// $ match at line boundaries of the block text.
+func compileContentPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	result := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		compiled, err := compileQuery(pattern, true)
+		if err != nil {
+			return nil, karma.Format(err, "invalid regexp: %q", pattern)
+		}
+
+		result = append(result, compiled)
+	}
+
+	return result, nil
+}
+
+// hasContents reports whether the block text matches all Contains patterns
+// and doesn't match any of NotContains patterns. The text is lines of the
+// block including leading comments attached by IncludeDocs, ancestors are
+// not part of it.
+func (options BlockOptions) hasContents(block Block) bool {
+	if len(options.Contains) == 0 && len(options.NotContains) == 0 {
+		return true
+	}
+
+	return options.matchContents(block.JoinLines(), nil)
+}
+
+// matchContents is like hasContents, but patterns in matched are considered
+// matching the text, they matched lines which are not in the text anymore.
+func (options BlockOptions) matchContents(
+	text string,
+	matched map[*regexp.Regexp]bool,
+) bool {
+	for _, pattern := range options.Contains {
+		if !matched[pattern] && !pattern.MatchString(text) {
+			return false
+		}
+	}
+
+	for _, pattern := range options.NotContains {
+		if matched[pattern] || pattern.MatchString(text) {
+			return false
+		}
 	}
 
-	return json.Marshal(export)
+	return true
 }
 
+// compileQuery compiles the query, in multiline mode ^ and $ match at line
+// boundaries like they do when the query is applied to separate lines.
+func compileQuery(query string, multiline bool) (*regexp.Regexp, error) {
+	if multiline {
+		query = "(?m)" + query
+	}
+
+	return regexp.Compile(query)
+}
+
+// OverlapPolicy defines what to do with matches inside of already found
+// blocks and with blocks touching each other.
+type OverlapPolicy string
+
+const (
+	// OverlapSkip doesn't start new blocks for matches inside of a found
+	// block, they are only recorded as matches of the block.
+	OverlapSkip OverlapPolicy = "skip"
+
+	// OverlapMerge merges overlapping and adjacent blocks into one.
+	OverlapMerge OverlapPolicy = "merge"
+
+	// OverlapNested emits a separate block for every match even if it's
+	// inside of another block.
+	OverlapNested OverlapPolicy = "nested"
+)
+
+// parseOverlapPolicy parses policy name, empty name means OverlapSkip.
+func parseOverlapPolicy(value string) (OverlapPolicy, error) {
+	switch policy := OverlapPolicy(value); policy {
+	case "":
+		return OverlapSkip, nil
+	case OverlapSkip, OverlapMerge, OverlapNested:
+		return policy, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown overlap policy: %q, expected skip, merge or nested",
+			value,
+		)
+	}
+}
+
+// UpToTop is the value of BlockOptions.Up which climbs to the top level.
+const UpToTop = -1
+
+// parseUp parses number of levels to climb from the matching line, "top"
+// means the top level.
+func parseUp(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if value == "top" {
+		return UpToTop, nil
+	}
+
+	up, err := strconv.Atoi(value)
+	if err != nil || up < 0 {
+		return 0, fmt.Errorf(
+			"expected non-negative number of levels or \"top\", got: %q",
+			value,
+		)
+	}
+
+	return up, nil
+}
+
+// parseSize parses size in bytes with optional K, M or G suffix, like 10M.
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	number := value
+
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+
+	if multiplier != 1 {
+		number = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf(
+			"expected non-negative size with optional K, M or G suffix, got: %q",
+			value,
+		)
+	}
+
+	return size * multiplier, nil
+}
+
+// errFileTooLarge is returned for files skipped because of
+// BlockOptions.MaxFileSize.
+var errFileTooLarge = errors.New("file is larger than the limit")
+
 func findBlocks(
 	filename string,
 	query *regexp.Regexp,
-	higherThan int,
+	options BlockOptions,
 ) (Blocks, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, karma.Format(err, "open file")
+	return findFileBlocks(File{Path: filename}, query, options)
+}
+
+// findFileBlocks is like findBlocks, but it searches virtual files as well.
+func findFileBlocks(
+	file File,
+	query *regexp.Regexp,
+	options BlockOptions,
+) (Blocks, error) {
+	// the file is opened first, so contents of archive members are always
+	// consumed
+	text, err := openTextFile(file, options.Encoding)
+	if err != nil || text == nil {
+		return nil, err
 	}
 
-	defer file.Close()
+	defer text.Close()
 
-	header := make([]byte, 256)
-	_, err = file.Read(header)
-	if err != nil && err != io.EOF {
-		return nil, karma.Format(err, "read header")
+	err = checkBlockStrategy(options.Strategy)
+	if err != nil {
+		return nil, err
 	}
 
-	kind := http.DetectContentType(header)
-
-	log.Debug("content type: " + kind)
+	filename := file.Path
 
-	if !strings.HasPrefix(kind, "text/plain") {
-		return nil, nil
+	if options.MaxFileSize > 0 && text.Size > options.MaxFileSize {
+		return nil, fmt.Errorf("%w: %d bytes", errFileTooLarge, options.MaxFileSize)
 	}
 
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		return nil, karma.Format(err, "file seek")
+	// size of pipes is unknown, so they are streamed as well
+	huge := text.Size > streamingThreshold || text.Size < 0
+	if huge && canStreamBlocks(filename, options) {
+		return streamBlocks(filename, text, query, options)
 	}
 
-	contents, err := ioutil.ReadAll(file)
+	contents, err := io.ReadAll(text)
 	if err != nil {
 		return nil, karma.Format(err, "read file")
 	}
 
-	lines := strings.Split(string(contents), "\n")
+	literal := options.Literal
+	if literal != "" && !bytes.Contains(contents, []byte(literal)) {
+		return nil, nil
+	}
+
+	return extractBlocks(filename, string(contents), query, options)
+}
 
-	indent, err := getIndentation(lines)
+// extractBlocks finds blocks matching the query in the contents of the given
+// file.
+func extractBlocks(
+	filename string,
+	contents string,
+	query *regexp.Regexp,
+	options BlockOptions,
+) (Blocks, error) {
+	strategy, err := getBlockStrategy(filename, options)
 	if err != nil {
 		return nil, err
 	}
 
-	max := func(a, b int) int {
-		if a > b {
-			return a
+	if options.Overlap == "" {
+		options.Overlap = OverlapSkip
+	}
+
+	lines := strings.Split(contents, "\n")
+
+	literal := options.Literal
+
+	getMatches := func(index int) []BlockMatch {
+		if !strings.Contains(lines[index], literal) {
+			return nil
 		}
 
-		return b
+		return findLineMatches(query, lines[index], index+1)
 	}
 
+	if options.Multiline {
+		getMatches = findMultilineMatches(query, contents, lines)
+	}
+
+	strategy.Prepare(lines)
+
+	var (
+		prefixes = getLeadingPrefixes(filename)
+		tabWidth = getIndentation(filename, options).TabWidth
+	)
+
 	result := []Block{}
+
+	// candidates holds the last lines of every level above the scanned line,
+	// so ancestors are found in one pass over the file
+	var (
+		candidates = []ancestor{}
+		scanned    = 0
+	)
+
+	// free is the first line which doesn't belong to any block yet
+	free := 0
 	for lineIndex := 0; lineIndex < len(lines); lineIndex++ {
-		text := lines[lineIndex]
+		matches := getMatches(lineIndex)
+		if len(matches) == 0 {
+			continue
+		}
 
-		if query.Match([]byte(text)) {
-			lineLevel := getIndentationLevel(text, indent)
+		for ; scanned < lineIndex; scanned++ {
+			updateAncestors(
+				&candidates,
+				lines[scanned],
+				scanned,
+				strategy.GetLevel(lines, scanned),
+				prefixes,
+			)
+		}
+
+		start := lineIndex
+		ancestors := getAncestors(candidates, strategy.GetLevel(lines, start))
+
+		if options.Up != 0 && len(ancestors) > 0 {
+			level := len(ancestors) - options.Up
+			if options.Up < 0 || level < 0 {
+				level = 0
+			}
+
+			start = ancestors[level].Line - 1
+			ancestors = ancestors[:level]
+		}
+
+		if options.Overlap == OverlapSkip && start < free {
+			// the enclosing block is already found for a previous match
+			result[len(result)-1].Matches = append(
+				result[len(result)-1].Matches,
+				matches...,
+			)
+
+			continue
+		}
 
-			block := []BlockLine{
-				{
-					Line: lineIndex + 1,
-					Text: text,
-				},
+		begin := start
+		if options.IncludeDocs {
+			limit := 0
+			if options.Overlap == OverlapSkip {
+				limit = free
 			}
 
-			nextLine := lineIndex + 1
-			for ; nextLine < len(lines); nextLine++ {
-				if lines[nextLine] == "" ||
-					getIndentationLevel(
-						lines[nextLine],
-						indent,
-					) > max(lineLevel+higherThan, 0) {
-					block = append(block, BlockLine{
-						Line: nextLine + 1,
-						Text: lines[nextLine],
-					})
-				} else {
-					if len(block) > 1 {
-						block = append(block, BlockLine{
-							Line: nextLine + 1,
-							Text: lines[nextLine],
-						})
-					}
-					break
+			begin = getLeadingLineStart(
+				lines,
+				start,
+				limit,
+				prefixes,
+				tabWidth,
+			)
+		}
+
+		end := strategy.GetBlockEnd(lines, start)
+		if matchesEnd := getMatchesEnd(matches); matchesEnd > end {
+			end = matchesEnd
+		}
+
+		if len(result) > 0 {
+			last := &result[len(result)-1]
+
+			switch {
+			case options.Overlap == OverlapMerge &&
+				begin <= last.GetLineEnd():
+				last.Matches = append(last.Matches, matches...)
+				last.extend(lines, end)
+				continue
+
+			case options.Overlap == OverlapNested &&
+				begin == last.GetLineStart()-1 &&
+				end == last.GetLineEnd()-1:
+				last.Matches = append(last.Matches, matches...)
+				continue
+			}
+		}
+
+		block := newBlock(lines, begin, end)
+		block.Ancestors = ancestors
+		block.Matches = matches
+
+		if options.Overlap == OverlapSkip {
+			// matches inside of the block don't start new blocks
+			for index := lineIndex + 1; index <= end; index++ {
+				inner := getMatches(index)
+				if innerEnd := getMatchesEnd(inner); innerEnd > end {
+					block.extend(lines, innerEnd)
+					end = innerEnd
 				}
+
+				block.Matches = append(block.Matches, inner...)
 			}
 
-			result = append(result, Block(block))
+			if end > lineIndex {
+				lineIndex = end
+			}
 
-			lineIndex = nextLine
-			continue
+			free = end + 1
 		}
+
+		result = append(result, block)
 	}
 
-	return result, nil
+	// merged blocks are complete only now, so contents are checked at last
+	// and before truncating, so lines below the cut are checked as well
+	filtered := result[:0]
+	for _, block := range result {
+		if options.hasContents(block) {
+			block.truncate(options.MaxBlockLines)
+			filtered = append(filtered, block)
+		}
+	}
+
+	return filtered, nil
 }
 
-func getIndentation(lines []string) (byte, error) {
+// Indentation describes how indentation of the file is measured.
+type Indentation struct {
+	// TabWidth is the number of columns a tab character advances to.
+	TabWidth int
+
+	// Size is the number of columns of a single indentation level, zero
+	// means it's detected from the file contents.
+	Size int
+}
+
+const defaultTabWidth = 8
+
+// getIndentation returns indentation settings of the given file, the tab
+// width passed via options overrides .editorconfig which overrides the
+// default one.
+func getIndentation(filename string, options BlockOptions) Indentation {
+	indentation := Indentation{
+		TabWidth: options.DefaultTabWidth,
+	}
+
+	if indentation.TabWidth <= 0 {
+		indentation.TabWidth = defaultTabWidth
+	}
+
+	properties := getEditorConfigProperties(filename)
+
+	if size, err := strconv.Atoi(properties["indent_size"]); err == nil && size > 0 {
+		indentation.Size = size
+		indentation.TabWidth = size
+	}
+
+	if width, err := strconv.Atoi(properties["tab_width"]); err == nil && width > 0 {
+		indentation.TabWidth = width
+	}
+
+	if options.TabWidth > 0 {
+		indentation.TabWidth = options.TabWidth
+	}
+
+	if properties["indent_size"] == "tab" ||
+		properties["indent_style"] == "tab" && indentation.Size == 0 {
+		indentation.Size = indentation.TabWidth
+	}
+
+	return indentation
+}
+
+// detectIndentationSize returns the tab width if the file is indented by tabs
+// and a single column otherwise.
+func detectIndentationSize(lines []string, tabWidth int) int {
 	for _, line := range lines {
 		if line == "" {
 			continue
 		}
 		if line[0] == '\t' {
-			return '\t', nil
+			return tabWidth
 		}
 		if line[0] == ' ' {
-			return ' ', nil
+			return 1
 		}
 	}
 
-	return ' ', nil
+	return 1
 }
 
-func getIndentationLevel(line string, indent byte) int {
+// getIndentationLevel returns width of the leading whitespace of the line in
+// columns, so tabs and spaces can be mixed.
+func getIndentationLevel(line string, tabWidth int) int {
+	level := 0
 	for i := 0; i < len(line); i++ {
-		if line[i] != indent {
-			return i
+		switch line[i] {
+		case ' ':
+			level++
+		case '\t':
+			level += tabWidth - level%tabWidth
+		default:
+			return level
 		}
 	}
 
@@ -335,19 +1105,42 @@ func formatLine(
 	return text
 }
 
-func filterBlocks(blocks Blocks, filters []*AwkwardMatcher) (Blocks, error) {
+// getLexer returns chroma lexer for the file, the plaintext one if the
+// language is unknown.
+func getLexer(filename string) chroma.Lexer {
+	lexer := lexers.Match(filename)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	return lexer
+}
+
+// filterBlocks keeps blocks satisfying the AWK conditions combined according
+// to the mode.
+func filterBlocks(
+	filename string,
+	blocks Blocks,
+	filters []*AwkwardMatcher,
+	mode AwkMode,
+) (Blocks, error) {
 	if len(filters) == 0 {
 		return blocks, nil
 	}
 
 	result := Blocks{}
 
+	lang := getLexer(filename).Config().Name
+
 	for _, block := range blocks {
 		lines := block.JoinLines()
+		vars := getAwkVars(filename, lang, block)
 
-		found := false
+		// any stops at the first matching condition, all and none stop at
+		// the first condition deciding the result otherwise
+		found := mode == AwkAll || mode == AwkNone
 		for _, filter := range filters {
-			ok, err := filter.Match(lines)
+			ok, err := filter.Match(lines, vars)
 			if err != nil {
 				return nil, karma.
 					Describe("condition", filter.Condition).
@@ -359,10 +1152,15 @@ func filterBlocks(blocks Blocks, filters []*AwkwardMatcher) (Blocks, error) {
 
 			}
 
-			if ok {
+			if mode == AwkAny && ok {
 				found = true
 				break
 			}
+
+			if mode == AwkAll && !ok || mode == AwkNone && ok {
+				found = false
+				break
+			}
 		}
 
 		if found {
@@ -371,3 +1169,43 @@ func filterBlocks(blocks Blocks, filters []*AwkwardMatcher) (Blocks, error) {
 	}
 	return result, nil
 }
+
+// printBlocks runs the --awk-print program for every block, blocks the
+// program printed nothing for are dropped.
+func printBlocks(
+	filename string,
+	blocks Blocks,
+	printer *AwkwardPrinter,
+) (Blocks, error) {
+	if printer == nil {
+		return blocks, nil
+	}
+
+	result := Blocks{}
+
+	lang := getLexer(filename).Config().Name
+
+	for _, block := range blocks {
+		lines := block.JoinLines()
+
+		output, err := printer.Print(lines, getAwkVars(filename, lang, block))
+		if err != nil {
+			return nil, karma.
+				Describe("program", printer.Program).
+				Describe("block", lines).
+				Format(
+					err,
+					"print block using program",
+				)
+		}
+
+		if output == "" {
+			continue
+		}
+
+		block.Output = output
+		result = append(result, block)
+	}
+
+	return result, nil
+}
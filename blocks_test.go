@@ -0,0 +1,870 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestFile(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func getLineRanges(blocks Blocks) [][2]int {
+	ranges := [][2]int{}
+	for _, block := range blocks {
+		ranges = append(
+			ranges,
+			[2]int{block.GetLineStart(), block.GetLineEnd()},
+		)
+	}
+
+	return ranges
+}
+
+func TestFindBlocks_BraceLanguage(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "main.go", `package main
+
+func Foo(
+a int,
+) {
+if a > 0 { return }
+s := "}"
+// }
+switch {
+}
+}
+
+func Bar()
+{
+	x := '{'
+}
+else
+{
+}
+
+func Baz() { return }
+func Qux() {
+	if x {
+	} else {
+	}
+}
+`)
+
+	testcases := []struct {
+		query string
+		lines [][2]int
+	}{
+		{query: `func Foo\(`, lines: [][2]int{{3, 11}}},
+		{query: `func Bar\(`, lines: [][2]int{{13, 19}}},
+		{query: `func Baz\(`, lines: [][2]int{{21, 21}}},
+		{query: `if a > 0`, lines: [][2]int{{6, 6}}},
+		{query: `} else {`, lines: [][2]int{{24, 25}}},
+		{query: `func Qux\(`, lines: [][2]int{{22, 26}}},
+	}
+
+	for _, testcase := range testcases {
+		blocks, err := findBlocks(
+			path,
+			regexp.MustCompile(testcase.query),
+			BlockOptions{},
+		)
+		test.NoError(err, testcase.query)
+
+		test.Equal(testcase.lines, getLineRanges(blocks), testcase.query)
+	}
+
+	// lifetimes are not character literals
+	path = writeTestFile(t, "lib.rs", `fn f<'a>(y: &'a str) {
+    let s: S<'a> = S { x: &'a y };
+    let c = '{';
+    let q = '\'';
+    let u = '\u{1F600}';
+    needle();
+}
+
+fn g() {
+}
+`)
+
+	blocks, err := findBlocks(path, regexp.MustCompile(`fn f`), BlockOptions{})
+	test.NoError(err)
+	test.Equal([][2]int{{1, 7}}, getLineRanges(blocks))
+}
+
+func TestFindBlocks_Strategies(t *testing.T) {
+	test := assert.New(t)
+
+	testcases := []struct {
+		name     string
+		contents string
+		query    string
+		strategy string
+		lines    [][2]int
+	}{
+		{
+			name: "a.rb",
+			contents: `class Foo
+  def bar(x)
+    return 1 if x
+    while x do
+      x -= 1
+    end
+  end
+end`,
+			query: `def bar`,
+			lines: [][2]int{{2, 7}},
+		},
+		{
+			name: "a.sh",
+			contents: `foo() {
+  if [ -f x ]; then
+    echo "fi"
+  fi
+}
+echo ${x}`,
+			query: `if \[`,
+			lines: [][2]int{{2, 4}},
+		},
+		{
+			name: "a.sql",
+			contents: `BEGIN
+  IF x THEN
+    y := CASE WHEN a THEN 1 END;
+  END IF;
+END;`,
+			query: `BEGIN`,
+			lines: [][2]int{{1, 5}},
+		},
+		{
+			name: "a.html",
+			contents: `<div>
+  <br>
+  <p
+    class="b">text</p>
+</div>`,
+			query: `<div`,
+			lines: [][2]int{{1, 5}},
+		},
+		{
+			name: "a.md",
+			contents: `# Title
+## Sub
+text
+## Sub2
+`,
+			query: `^## Sub$`,
+			lines: [][2]int{{2, 3}},
+		},
+		{
+			name: "a.txt",
+			contents: `foo {
+bar
+}`,
+			query:    `foo`,
+			strategy: "brace",
+			lines:    [][2]int{{1, 3}},
+		},
+	}
+
+	for _, testcase := range testcases {
+		path := writeTestFile(t, testcase.name, testcase.contents)
+
+		blocks, err := findBlocks(
+			path,
+			regexp.MustCompile(testcase.query),
+			BlockOptions{Strategy: testcase.strategy},
+		)
+		test.NoError(err, testcase.name)
+
+		test.Equal(testcase.lines, getLineRanges(blocks), testcase.name)
+	}
+}
+
+func TestFindBlocks_TabWidth(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.py", "def foo():\n\tif x:\n        y()\n\tz()\n")
+	query := regexp.MustCompile(`if x`)
+
+	blocks, err := findBlocks(path, query, BlockOptions{})
+	test.NoError(err)
+	test.Equal([][2]int{{2, 2}}, getLineRanges(blocks))
+
+	blocks, err = findBlocks(path, query, BlockOptions{TabWidth: 4})
+	test.NoError(err)
+	test.Equal([][2]int{{2, 4}}, getLineRanges(blocks))
+
+	err = os.WriteFile(
+		filepath.Join(filepath.Dir(path), ".editorconfig"),
+		[]byte("root = true\n\n[*.{py,js}]\nindent_style = tab\ntab_width = 4\n"),
+		0644,
+	)
+	test.NoError(err)
+
+	blocks, err = findBlocks(path, query, BlockOptions{DefaultTabWidth: 2})
+	test.NoError(err)
+	test.Equal([][2]int{{2, 4}}, getLineRanges(blocks))
+}
+
+func TestFindBlocks_IncludeDocs(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.py", `import os
+# unrelated
+
+class A:
+    # comment
+    @decorator
+    def handler(self):
+        pass
+`)
+
+	query := regexp.MustCompile(`def handler`)
+
+	blocks, err := findBlocks(path, query, BlockOptions{})
+	test.NoError(err)
+	test.Equal([][2]int{{7, 9}}, getLineRanges(blocks))
+
+	blocks, err = findBlocks(path, query, BlockOptions{IncludeDocs: true})
+	test.NoError(err)
+	test.Equal([][2]int{{5, 9}}, getLineRanges(blocks))
+
+	path = writeTestFile(t, "a.ts", `class A {
+  @Input()
+  handler() {
+  }
+}
+`)
+
+	query = regexp.MustCompile(`handler\(`)
+
+	blocks, err = findBlocks(path, query, BlockOptions{IncludeDocs: true})
+	test.NoError(err)
+	test.Equal([][2]int{{2, 4}}, getLineRanges(blocks))
+
+	// Go has no decorators, so the line of the raw string is not attached
+	path = writeTestFile(t, "a.go", "package main\n\nvar s = `\n@x`\nfunc handler() {\n}\n")
+
+	blocks, err = findBlocks(path, query, BlockOptions{IncludeDocs: true})
+	test.NoError(err)
+	test.Equal([][2]int{{5, 6}}, getLineRanges(blocks))
+}
+
+func TestFindBlocks_IncludeDocs_BlockComment(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func foo(p *int) {
+	*p = 1
+	panic(1)
+
+	/* one line */
+	*p = 2
+	panic(2)
+}
+
+/**
+ * Bar bars.
+ */
+// Deprecated.
+func Bar() {
+}
+`)
+
+	blocks, err := findBlocks(
+		path,
+		regexp.MustCompile(`panic|^func Bar`),
+		BlockOptions{IncludeDocs: true, Overlap: OverlapNested},
+	)
+	test.NoError(err)
+
+	// dereferences are statements, not continuation of comments
+	test.Equal([][2]int{{5, 5}, {9, 9}, {12, 17}}, getLineRanges(blocks))
+}
+
+func TestFindBlocks_Ancestors(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.yaml", `root:
+  # comment
+  items:
+    - name: a
+  other:
+    - name: b
+      value: 1
+`)
+
+	blocks, err := findBlocks(
+		path,
+		regexp.MustCompile(`value`),
+		BlockOptions{},
+	)
+	test.NoError(err)
+	test.Len(blocks, 1)
+	test.Equal(
+		[]BlockLine{
+			{Line: 1, Text: "root:"},
+			{Line: 5, Text: "  other:"},
+			{Line: 6, Text: "    - name: b"},
+		},
+		blocks[0].Ancestors,
+	)
+	test.Equal("root: > other: > - name: b", blocks[0].FormatAncestors())
+	test.Equal(
+		[]string{path + "\n» root: > other: > - name: b\n7:      value: 1\n8:"},
+		blocks.Format(false, path, true, false),
+	)
+
+	// every line is prefixed with the filename, so it can be grepped
+	test.Equal(
+		[]string{
+			path + ":» root: > other: > - name: b\n" +
+				path + ":7:      value: 1\n" +
+				path + ":8:",
+		},
+		blocks.Format(true, path, true, false),
+	)
+
+	// the breadcrumb of a single header isn't taken for a line of code
+	path = writeTestFile(t, "a.py", "a:\n  b\n")
+
+	blocks, err = findBlocks(path, regexp.MustCompile(`b`), BlockOptions{})
+	test.NoError(err)
+	test.Equal(
+		[]string{path + "\n» a:\n  b\n"},
+		blocks.Format(false, path, false, false),
+	)
+}
+
+func TestFindBlocks_Ancestors_WrappedHeader(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func foo(
+	a int,
+	b int,
+) error {
+	if a > b {
+		panic("a")
+	}
+
+	return nil
+}
+`)
+
+	blocks, err := findBlocks(path, regexp.MustCompile(`panic`), BlockOptions{})
+	test.NoError(err)
+	test.Len(blocks, 1)
+	test.Equal(
+		[]BlockLine{
+			{Line: 3, Text: "func foo("},
+			{Line: 7, Text: "\tif a > b {"},
+		},
+		blocks[0].Ancestors,
+	)
+
+	path = writeTestFile(t, "a.py", `class A:
+    def foo(
+        self,
+        a,
+    ):
+        if a:
+            raise Error()
+`)
+
+	blocks, err = findBlocks(path, regexp.MustCompile(`raise`), BlockOptions{})
+	test.NoError(err)
+	test.Len(blocks, 1)
+	test.Equal("class A: > def foo( > if a:", blocks[0].FormatAncestors())
+}
+
+func TestFindBlocks_Ancestors_Strategy(t *testing.T) {
+	test := assert.New(t)
+
+	testcases := []struct {
+		name      string
+		contents  string
+		query     string
+		ancestors string
+	}{
+		{
+			// nesting of braces wins over the broken indentation
+			name:      "a.go",
+			contents:  "func foo() {\nif x {\n  }\n    for {\npanic(1)\n}\n}\n",
+			query:     `panic`,
+			ancestors: "func foo() { > for {",
+		},
+		{
+			name:      "a.go",
+			contents:  "func foo()\n{\n\tpanic(1)\n}\n",
+			query:     `panic`,
+			ancestors: "func foo()",
+		},
+		{
+			name:      "a.rb",
+			contents:  "class A\ndef foo\nraise 'x'\nend\nend\n",
+			query:     `raise`,
+			ancestors: "class A > def foo",
+		},
+		{
+			name:      "a.md",
+			contents:  "# A\n\n## B\n\ntext\n\n## C\n\nneedle\n",
+			query:     `needle`,
+			ancestors: "# A > ## C",
+		},
+	}
+
+	for _, testcase := range testcases {
+		path := writeTestFile(t, testcase.name, testcase.contents)
+
+		blocks, err := findBlocks(path, regexp.MustCompile(testcase.query), BlockOptions{})
+		test.NoError(err)
+		test.Len(blocks, 1, testcase.name)
+		test.Equal(testcase.ancestors, blocks[0].FormatAncestors(), testcase.name)
+	}
+
+	// -u climbs levels of the strategy
+	path := writeTestFile(t, "b.go", "func foo() {\nif x {\npanic(1)\n}\n}\n")
+
+	blocks, err := findBlocks(path, regexp.MustCompile(`panic`), BlockOptions{Up: 1})
+	test.NoError(err)
+	test.Equal([][2]int{{2, 4}}, getLineRanges(blocks))
+}
+
+func TestFindBlocks_Up(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func Foo() {
+	if x {
+		panic("a")
+	}
+
+	panic("b")
+}
+`)
+
+	query := regexp.MustCompile(`panic\("a"`)
+
+	blocks, err := findBlocks(path, query, BlockOptions{Up: 1})
+	test.NoError(err)
+	test.Equal([][2]int{{4, 6}}, getLineRanges(blocks))
+
+	blocks, err = findBlocks(path, query, BlockOptions{Up: 5})
+	test.NoError(err)
+	test.Equal([][2]int{{3, 9}}, getLineRanges(blocks))
+
+	blocks, err = findBlocks(
+		path,
+		regexp.MustCompile(`panic\(`),
+		BlockOptions{Up: UpToTop},
+	)
+	test.NoError(err)
+	test.Equal([][2]int{{3, 9}}, getLineRanges(blocks))
+
+	// the enclosing function of a wrapped signature starts at its first line
+	path = writeTestFile(t, "b.go", `package main
+
+func Foo(
+	a int,
+) error {
+	if a > 0 {
+		panic(a)
+	}
+
+	return nil
+}
+`)
+
+	blocks, err = findBlocks(
+		path,
+		regexp.MustCompile(`panic\(`),
+		BlockOptions{Up: UpToTop},
+	)
+	test.NoError(err)
+	test.Equal([][2]int{{3, 11}}, getLineRanges(blocks))
+	test.Empty(blocks[0].Ancestors)
+
+	path = writeTestFile(t, "b.py", `def foo(
+    a,
+):
+    if a:
+        raise Error()
+`)
+
+	blocks, err = findBlocks(
+		path,
+		regexp.MustCompile(`raise`),
+		BlockOptions{Up: UpToTop},
+	)
+	test.NoError(err)
+	test.Equal([][2]int{{1, 6}}, getLineRanges(blocks))
+}
+
+func TestFindBlocks_Overlap(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func Foo() {
+	panic("a")
+}
+func Bar() {
+	panic("b")
+}
+`)
+
+	query := regexp.MustCompile(`func|panic`)
+
+	testcases := []struct {
+		overlap OverlapPolicy
+		lines   [][2]int
+		matches [][]int
+	}{
+		{
+			overlap: OverlapSkip,
+			lines:   [][2]int{{3, 5}, {6, 8}},
+			matches: [][]int{{3, 4}, {6, 7}},
+		},
+		{
+			overlap: OverlapMerge,
+			lines:   [][2]int{{3, 8}},
+			matches: [][]int{{3, 4, 6, 7}},
+		},
+		{
+			overlap: OverlapNested,
+			lines:   [][2]int{{3, 5}, {4, 4}, {6, 8}, {7, 7}},
+			matches: [][]int{{3}, {4}, {6}, {7}},
+		},
+	}
+
+	for _, testcase := range testcases {
+		blocks, err := findBlocks(
+			path,
+			query,
+			BlockOptions{Overlap: testcase.overlap},
+		)
+		test.NoError(err)
+		test.Equal(testcase.lines, getLineRanges(blocks), testcase.overlap)
+
+		matches := [][]int{}
+		for _, block := range blocks {
+			lines := []int{}
+			for _, match := range block.Matches {
+				lines = append(lines, match.Line)
+			}
+
+			matches = append(matches, lines)
+		}
+
+		test.Equal(testcase.matches, matches, testcase.overlap)
+	}
+}
+
+func TestFindLineMatches(t *testing.T) {
+	test := assert.New(t)
+
+	matches := findLineMatches(
+		regexp.MustCompile(`(?P<key>\w+)=(\d+)?`),
+		"ключ a=1 b=",
+		3,
+	)
+
+	test.Equal(
+		[]BlockMatch{
+			{
+				Line: 3, LineEnd: 3, Start: 9, End: 12, Column: 6, Text: "a=1",
+				Submatches: []Submatch{
+					{Index: 1, Name: "key", Start: 9, End: 10, Text: "a"},
+					{Index: 2, Start: 11, End: 12, Text: "1"},
+				},
+			},
+			{
+				Line: 3, LineEnd: 3, Start: 13, End: 15, Column: 10,
+				Text: "b=",
+				Submatches: []Submatch{
+					{Index: 1, Name: "key", Start: 13, End: 14, Text: "b"},
+					{Index: 2, Start: -1, End: -1},
+				},
+			},
+		},
+		matches,
+	)
+}
+
+func TestHighlightSpans(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal(
+		"\x1b[1m\x1b[7mfu\x1b[0m\x1b[7mnc\x1b[27m x",
+		highlightSpans("\x1b[1mfu\x1b[0mnc x", [][2]int{{0, 4}}),
+	)
+}
+
+func TestFindBlocks_Multiline(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.py", `def foo():
+    if err:
+        return None
+    if err:
+        raise err
+`)
+
+	blocks, err := findBlocks(
+		path,
+		regexp.MustCompile(`if err:\s*return`),
+		BlockOptions{},
+	)
+	test.NoError(err)
+	test.Len(blocks, 0)
+
+	query, err := compileQuery(`^\s+if err:\s*return (?P<value>\w+)`, true)
+	test.NoError(err)
+
+	blocks, err = findBlocks(path, query, BlockOptions{Multiline: true})
+	test.NoError(err)
+	test.Equal([][2]int{{2, 4}}, getLineRanges(blocks))
+	test.Len(blocks[0].Matches, 1)
+
+	match := blocks[0].Matches[0]
+	test.Equal(2, match.Line)
+	test.Equal(3, match.LineEnd)
+	test.Equal(0, match.Start)
+	test.Equal(len("        return None"), match.End)
+	test.Equal("None", match.Submatches[0].Text)
+}
+
+func TestFindBlocks_Contains(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func FooHandler() {
+	<-ctx.Done()
+}
+
+func BarHandler() {
+	defer cancel()
+	<-ctx.Done()
+}
+
+func BazHandler() {
+}
+`)
+
+	contains, err := compileContentPatterns([]string{`ctx\.Done\(\)`})
+	test.NoError(err)
+
+	notContains, err := compileContentPatterns([]string{`^\s*defer cancel`})
+	test.NoError(err)
+
+	blocks, err := findBlocks(
+		path,
+		regexp.MustCompile(`func .*Handler`),
+		BlockOptions{Contains: contains},
+	)
+	test.NoError(err)
+	test.Equal([][2]int{{3, 5}, {7, 10}}, getLineRanges(blocks))
+
+	blocks, err = findBlocks(
+		path,
+		regexp.MustCompile(`func .*Handler`),
+		BlockOptions{Contains: contains, NotContains: notContains},
+	)
+	test.NoError(err)
+	test.Equal([][2]int{{3, 5}}, getLineRanges(blocks))
+
+	// ancestors are not part of the text, attached comments are
+	path = writeTestFile(t, "b.go", `package main
+
+// Deprecated: use BarHandler.
+func FooHandler() {
+	<-ctx.Done()
+}
+`)
+
+	contains, err = compileContentPatterns([]string{`FooHandler|Deprecated`})
+	test.NoError(err)
+
+	blocks, err = findBlocks(
+		path,
+		regexp.MustCompile(`ctx\.Done`),
+		BlockOptions{Contains: contains},
+	)
+	test.NoError(err)
+	test.Empty(blocks)
+
+	contains, err = compileContentPatterns([]string{`Deprecated`})
+	test.NoError(err)
+
+	blocks, err = findBlocks(
+		path,
+		regexp.MustCompile(`func FooHandler`),
+		BlockOptions{Contains: contains, IncludeDocs: true},
+	)
+	test.NoError(err)
+	test.Equal([][2]int{{3, 6}}, getLineRanges(blocks))
+}
+
+func TestFilterBlocks_AwkMode(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func Foo() {
+	panic(1)
+}
+
+func Bar() {
+	defer recover()
+	panic(1)
+}
+
+func Baz() {
+}
+`)
+
+	blocks, err := findBlocks(path, regexp.MustCompile(`^func `), BlockOptions{})
+	test.NoError(err)
+
+	var filters []*AwkwardMatcher
+	for _, condition := range []string{`/panic/`, `/recover/`} {
+		matcher, err := NewAwkwardMatcher(condition)
+		test.NoError(err)
+
+		filters = append(filters, matcher)
+	}
+
+	testcases := map[AwkMode][][2]int{
+		AwkAny:  {{3, 5}, {7, 10}},
+		AwkAll:  {{7, 10}},
+		AwkNone: {{12, 13}},
+	}
+
+	for mode, expected := range testcases {
+		filtered, err := filterBlocks(path, blocks, filters, mode)
+		test.NoError(err)
+		test.Equal(expected, getLineRanges(filtered), string(mode))
+	}
+}
+
+func TestPrintBlocks(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func Foo() {
+	panic(1)
+}
+
+func Bar() {
+}
+`)
+
+	blocks, err := findBlocks(path, regexp.MustCompile(`^func `), BlockOptions{})
+	test.NoError(err)
+
+	printer, err := NewAwkwardPrinter(`NR == 1 && !/Bar/ { print LINE_START ": " $2 }`)
+	test.NoError(err)
+
+	blocks, err = printBlocks(path, blocks, printer)
+	test.NoError(err)
+	test.Len(blocks, 1)
+	test.Equal("3: Foo()", blocks[0].Output)
+	test.Equal([]string{path + "\n3: Foo()"}, blocks.Format(false, path, true, false))
+
+	_, err = NewAwkwardPrinter(`{ print`)
+	test.Error(err)
+}
+
+func TestFindBlocks_Limits(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func Foo() {
+	a := 1
+	b := 2
+	c := 3
+}
+`)
+
+	blocks, err := findBlocks(path, regexp.MustCompile(`^func |c :=`), BlockOptions{
+		MaxBlockLines: 2,
+	})
+	test.NoError(err)
+	test.Len(blocks, 1)
+	test.Equal(3, blocks[0].Truncated)
+	test.Equal([][2]int{{3, 4}}, getLineRanges(blocks))
+	test.Len(blocks[0].Matches, 1)
+	test.Equal(
+		[]string{path + "\n3:func Foo() {\n4:\ta := 1\n... truncated, 3 more lines"},
+		blocks.Format(false, path, true, false),
+	)
+
+	buffer, err := blocks.EncodeJSON(path)
+	test.NoError(err)
+	test.Contains(string(buffer), `"truncated":true`)
+
+	// contents are checked before the block is truncated
+	contains, err := compileContentPatterns([]string{`c :=`})
+	test.NoError(err)
+
+	blocks, err = findBlocks(path, regexp.MustCompile(`^func `), BlockOptions{
+		MaxBlockLines: 2,
+		Contains:      contains,
+	})
+	test.NoError(err)
+	test.Equal([][2]int{{3, 4}}, getLineRanges(blocks))
+
+	blocks, err = findBlocks(path, regexp.MustCompile(`^func `), BlockOptions{
+		MaxBlockLines: 2,
+		NotContains:   contains,
+	})
+	test.NoError(err)
+	test.Empty(blocks)
+
+	blocks, err = findBlocks(path, regexp.MustCompile(`^func `), BlockOptions{
+		MaxFileSize: 10,
+	})
+	test.ErrorIs(err, errFileTooLarge)
+	test.Empty(blocks)
+}
+
+func TestParseSize(t *testing.T) {
+	test := assert.New(t)
+
+	for value, expected := range map[string]int64{
+		"":    0,
+		"100": 100,
+		"2K":  2 << 10,
+		"10m": 10 << 20,
+		"1G":  1 << 30,
+	} {
+		size, err := parseSize(value)
+		test.NoError(err, value)
+		test.Equal(expected, size, value)
+	}
+
+	for _, value := range []string{"M", "-1", "1T", "1.5M"} {
+		_, err := parseSize(value)
+		test.Error(err, value)
+	}
+}
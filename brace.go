@@ -0,0 +1,306 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// braceSyntax describes string literals and comments of a C-family language,
+// so brackets inside of them are not counted as nesting.
+type braceSyntax struct {
+	lineComments []string
+	blockComment [2]string
+	// quotes start string literals that end on the same line.
+	quotes string
+	// rawQuotes start string literals that can span several lines.
+	rawQuotes string
+	// charQuotes start character literals like 'x' or '\n', the quote is
+	// not a literal unless it's closed right after the character.
+	charQuotes string
+	// attributes start lines of annotations, decorators and attributes.
+	attributes []string
+}
+
+var (
+	cSyntax = braceSyntax{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		attributes:   []string{"@", "#[", "#!["},
+	}
+
+	// rustSyntax has character literals instead of single quoted strings,
+	// so lifetimes like 'a are not mistaken for them.
+	rustSyntax = braceSyntax{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"`,
+		charQuotes:   `'`,
+		attributes:   []string{"#[", "#!["},
+	}
+
+	csSyntax = braceSyntax{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		attributes:   []string{"["},
+	}
+
+	goSyntax = braceSyntax{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		rawQuotes:    "`",
+	}
+
+	// jsSyntax is like goSyntax, but with decorators of classes and their
+	// members.
+	jsSyntax = braceSyntax{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		rawQuotes:    "`",
+		attributes:   []string{"@"},
+	}
+
+	phpSyntax = braceSyntax{
+		lineComments: []string{"//", "#"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+
+	cssSyntax = braceSyntax{
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+
+	jsonSyntax = braceSyntax{
+		quotes: `"`,
+	}
+)
+
+// braceLanguages maps file extensions to the syntax used for brace-aware
+// block termination.
+var braceLanguages = map[string]braceSyntax{
+	"go":     goSyntax,
+	"c":      cSyntax,
+	"h":      cSyntax,
+	"cc":     cSyntax,
+	"cpp":    cSyntax,
+	"cxx":    cSyntax,
+	"hh":     cSyntax,
+	"hpp":    cSyntax,
+	"cs":     csSyntax,
+	"java":   cSyntax,
+	"kt":     cSyntax,
+	"kts":    cSyntax,
+	"scala":  cSyntax,
+	"groovy": cSyntax,
+	"gradle": cSyntax,
+	"dart":   cSyntax,
+	"swift":  cSyntax,
+	"rs":     rustSyntax,
+	"proto":  cSyntax,
+	"js":     jsSyntax,
+	"jsx":    jsSyntax,
+	"mjs":    jsSyntax,
+	"cjs":    jsSyntax,
+	"ts":     jsSyntax,
+	"tsx":    jsSyntax,
+	"php":    phpSyntax,
+	"css":    cssSyntax,
+	"scss":   cSyntax,
+	"less":   cSyntax,
+	"json":   jsonSyntax,
+}
+
+// BraceStrategy terminates the block when all brackets opened in it are
+// closed, so indentation doesn't matter.
+type BraceStrategy struct {
+	Syntax braceSyntax
+
+	braces nestingLines
+}
+
+func (strategy *BraceStrategy) Name() string {
+	return "brace"
+}
+
+func (strategy *BraceStrategy) Prepare(lines []string) {
+	strategy.braces = scanBraces(lines, strategy.Syntax)
+}
+
+func (strategy *BraceStrategy) GetBlockEnd(lines []string, start int) int {
+	return strategy.braces.getBlockEnd(lines, start, isBraceContinuation)
+}
+
+func (strategy *BraceStrategy) GetLevel(lines []string, index int) int {
+	return strategy.braces.getLevel(index)
+}
+
+// scanBraces tracks {}, () and [] nesting through the whole file, skipping
+// string literals and comments.
+func scanBraces(lines []string, syntax braceSyntax) nestingLines {
+	var (
+		result         = make(nestingLines, len(lines))
+		depth          = 0
+		inBlockComment = false
+		rawQuote       = byte(0)
+	)
+
+	for index, line := range lines {
+		stat := nestingLine{start: depth, min: depth, max: depth}
+
+		for i := 0; i < len(line); {
+			if inBlockComment {
+				closing := strings.Index(line[i:], syntax.blockComment[1])
+				if closing < 0 {
+					break
+				}
+
+				i += closing + len(syntax.blockComment[1])
+				inBlockComment = false
+				continue
+			}
+
+			if rawQuote != 0 {
+				closing := strings.IndexByte(line[i:], rawQuote)
+				if closing < 0 {
+					break
+				}
+
+				i += closing + 1
+				rawQuote = 0
+				stat.tail = line[i-1]
+				continue
+			}
+
+			rest := line[i:]
+			if hasAnyPrefix(rest, syntax.lineComments) {
+				break
+			}
+
+			if syntax.blockComment[0] != "" &&
+				strings.HasPrefix(rest, syntax.blockComment[0]) {
+				inBlockComment = true
+				i += len(syntax.blockComment[0])
+				continue
+			}
+
+			char := line[i]
+			switch {
+			case strings.IndexByte(syntax.rawQuotes, char) >= 0:
+				rawQuote = char
+				i++
+				continue
+
+			case strings.IndexByte(syntax.quotes, char) >= 0:
+				i = skipStringLiteral(line, i)
+
+			case strings.IndexByte(syntax.charQuotes, char) >= 0:
+				i = skipCharLiteral(line, i)
+
+			case char == '{' || char == '(' || char == '[':
+				depth++
+				stat.update(depth)
+				i++
+
+			case char == '}' || char == ')' || char == ']':
+				depth--
+				stat.update(depth)
+				i++
+
+			default:
+				i++
+			}
+
+			if char != ' ' && char != '\t' {
+				stat.tail = char
+			}
+		}
+
+		stat.end = depth
+		result[index] = stat
+	}
+
+	return result
+}
+
+// skipStringLiteral returns position right after the string literal which
+// starts at the given position. Unterminated single quotes are not treated as
+// literals since they are used for other purposes, like lifetimes in Rust.
+func skipStringLiteral(line string, start int) int {
+	quote := line[start]
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+
+	if quote == '\'' {
+		return start + 1
+	}
+
+	return len(line)
+}
+
+// maxCharEscapeLength is the length of the longest escape sequence of
+// character literals, like \u{10FFFF} in Rust.
+const maxCharEscapeLength = 10
+
+// skipCharLiteral returns position right after the character literal which
+// starts at the given position, or right after the quote if the literal is
+// not closed within the length of a single character or an escape sequence.
+func skipCharLiteral(line string, start int) int {
+	quote := line[start]
+	rest := line[start+1:]
+
+	if strings.HasPrefix(rest, `\`) {
+		// the escaped character may be the quote itself
+		limit := min(len(rest), maxCharEscapeLength+1)
+		if limit > 2 {
+			if end := strings.IndexByte(rest[2:limit], quote); end >= 0 {
+				return start + 1 + 2 + end + 1
+			}
+		}
+
+		return start + 1
+	}
+
+	_, size := utf8.DecodeRuneInString(rest)
+	if size > 0 && size < len(rest) && rest[size] == quote {
+		return start + 1 + size + 1
+	}
+
+	return start + 1
+}
+
+// isBraceContinuation reports whether the block which is closed on the given
+// line continues on the next one: either the opening brace is placed on its
+// own line (Allman style) or the statement goes on with else/catch/finally.
+func isBraceContinuation(line nestingLine, next string) bool {
+	next = strings.TrimSpace(next)
+
+	if strings.HasPrefix(next, "{") {
+		switch line.tail {
+		case ',', ';', '}', ']':
+			return false
+		}
+
+		return true
+	}
+
+	for _, keyword := range []string{"else", "catch", "finally"} {
+		if next == keyword ||
+			strings.HasPrefix(next, keyword+" ") ||
+			strings.HasPrefix(next, keyword+"{") ||
+			strings.HasPrefix(next, keyword+"(") {
+			return line.tail == '}'
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+)
+
+// defaultLeadingPrefixes start comment and decorator lines in languages
+// without specific syntax, like Python, YAML or shell.
+var defaultLeadingPrefixes = []string{"#", "//", "@"}
+
+// getLeadingPrefixes returns prefixes of comment, decorator and attribute
+// lines that document a block in the given file.
+func getLeadingPrefixes(filename string) []string {
+	extension := getExtension(filename)
+
+	if syntax, ok := braceLanguages[extension]; ok {
+		prefixes := append([]string{}, syntax.lineComments...)
+		if syntax.blockComment[0] != "" {
+			prefixes = append(
+				prefixes,
+				syntax.blockComment[0],
+				syntax.blockComment[1],
+				// continuation of /** ... */ comments, attached only below
+				// the line opening the comment
+				"*",
+			)
+		}
+
+		return append(prefixes, syntax.attributes...)
+	}
+
+	if syntax, ok := keywordLanguages[extension]; ok {
+		return syntax.lineComments
+	}
+
+	// lines starting with # are headings of markdown, not comments
+	if headingLanguages[extension] == '#' {
+		return []string{"<!--"}
+	}
+
+	return defaultLeadingPrefixes
+}
+
+// getLeadingLineStart walks upward from the given line and returns index of
+// the first line of contiguous comments and decorators placed right above it
+// at the same indentation, but not above the given limit.
+func getLeadingLineStart(
+	lines []string,
+	start int,
+	limit int,
+	prefixes []string,
+	tabWidth int,
+) int {
+	level := getIndentationLevel(lines[start], tabWidth)
+
+	var (
+		begin = start
+
+		// inside is true while lines starting with a star are walked, they
+		// belong to a comment only if the line opening it is found above,
+		// otherwise they are statements like `*p = 1`
+		inside = false
+	)
+
+	for index := start - 1; index >= limit; index-- {
+		trimmed := strings.TrimLeft(lines[index], " \t")
+		if trimmed == "" || !hasAnyPrefix(trimmed, prefixes) {
+			break
+		}
+
+		lineLevel := getIndentationLevel(lines[index], tabWidth)
+
+		// javadoc-like comments align their stars by a single space
+		star := strings.HasPrefix(trimmed, "*")
+		aligned := lineLevel == level+1 && star
+		if lineLevel != level && !aligned {
+			break
+		}
+
+		if star {
+			inside = true
+			continue
+		}
+
+		if inside && !isBlockCommentStart(trimmed) {
+			break
+		}
+
+		inside = false
+		begin = index
+	}
+
+	return begin
+}
+
+// isBlockCommentStart reports whether the line opens a /* ... */ comment
+// which continues on the following lines.
+func isBlockCommentStart(line string) bool {
+	return strings.HasPrefix(line, "/*") &&
+		!strings.Contains(line[len("/*"):], "*/")
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeModified = "modified"
+)
+
+// diffContext is the number of unchanged lines around changed ones in
+// diffs of modified blocks.
+const diffContext = 3
+
+const (
+	ansiAdded   = "\x1b[32m"
+	ansiRemoved = "\x1b[31m"
+	ansiHunk    = "\x1b[36m"
+	ansiReset   = "\x1b[0m"
+)
+
+// BlockChange is a block added, removed or modified between two revisions.
+type BlockChange struct {
+	Status string
+
+	// Path is the path of the file without the revision prefix.
+	Path string
+
+	// Old is the block of the first revision, nil for added blocks.
+	Old *Block
+
+	// New is the block of the second revision, nil for removed blocks.
+	New *Block
+}
+
+type BlockChangeExport struct {
+	Status    string       `json:"status"`
+	Filename  string       `json:"filename"`
+	Header    string       `json:"header"`
+	Ancestors []BlockLine  `json:"ancestors"`
+	Old       *BlockExport `json:"old"`
+	New       *BlockExport `json:"new"`
+	Diff      string       `json:"diff,omitempty"`
+}
+
+// compareRevisions finds blocks matching the query in files of both
+// revisions under the given paths and returns changes between them ordered
+// by files and lines.
+func compareRevisions(
+	walker *FileWalker,
+	from string,
+	to string,
+	paths []string,
+	jobs int,
+	query *regexp.Regexp,
+	options BlockOptions,
+) []BlockChange {
+	// the statement following a block starts the next block, otherwise
+	// renaming the next function looks like a change of the previous one
+	options.SkipTerminatingLine = true
+
+	var (
+		before = findRevisionBlocks(walker, from, paths, jobs, query, options)
+		after  = findRevisionBlocks(walker, to, paths, jobs, query, options)
+	)
+
+	files := []string{}
+	for path := range before {
+		files = append(files, path)
+	}
+
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			files = append(files, path)
+		}
+	}
+
+	sort.Strings(files)
+
+	changes := []BlockChange{}
+	for _, path := range files {
+		changes = append(changes, compareBlocks(path, before[path], after[path])...)
+	}
+
+	return changes
+}
+
+// findRevisionBlocks returns blocks found in files of the revision by their
+// paths without the revision prefix.
+func findRevisionBlocks(
+	walker *FileWalker,
+	revision string,
+	paths []string,
+	jobs int,
+	query *regexp.Regexp,
+	options BlockOptions,
+) map[string]Blocks {
+	revisionWalker := *walker
+	revisionWalker.Revision = revision
+
+	search := func(file File) searchResult {
+		blocks, err := findFileBlocks(file, query, options)
+
+		return searchResult{Path: file.Path, Blocks: blocks, Err: err}
+	}
+
+	found := map[string]Blocks{}
+	searchFiles(&revisionWalker, paths, jobs, search, func(result searchResult) bool {
+		if result.Err != nil {
+			logSearchError(result)
+			return true
+		}
+
+		if len(result.Blocks) != 0 {
+			path := strings.TrimPrefix(result.Path, revision+archiveSeparator)
+			found[path] = result.Blocks
+		}
+
+		return true
+	})
+
+	return found
+}
+
+// compareBlocks pairs blocks of the file by their ancestors and header
+// lines, blocks with the same key are paired in the order they appear.
+// Paired blocks with different lines are modified, the rest are added or
+// removed.
+func compareBlocks(path string, before Blocks, after Blocks) []BlockChange {
+	previous := map[string][]int{}
+	for i, block := range before {
+		key := getBlockKey(path, block)
+		previous[key] = append(previous[key], i)
+	}
+
+	var (
+		changes = []BlockChange{}
+		paired  = map[int]bool{}
+	)
+
+	for i := range after {
+		block := &after[i]
+
+		key := getBlockKey(path, *block)
+		if len(previous[key]) == 0 {
+			changes = append(changes, BlockChange{
+				Status: changeAdded,
+				Path:   path,
+				New:    block,
+			})
+
+			continue
+		}
+
+		index := previous[key][0]
+		previous[key] = previous[key][1:]
+		paired[index] = true
+
+		if before[index].JoinLines() != block.JoinLines() {
+			changes = append(changes, BlockChange{
+				Status: changeModified,
+				Path:   path,
+				Old:    &before[index],
+				New:    block,
+			})
+		}
+	}
+
+	for i := range before {
+		if !paired[i] {
+			changes = append(changes, BlockChange{
+				Status: changeRemoved,
+				Path:   path,
+				Old:    &before[i],
+			})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].getLine() < changes[j].getLine()
+	})
+
+	return changes
+}
+
+// getBlockKey returns ancestors and the header line of the block, leading
+// comments and decorators are skipped and indentation is ignored, so
+// documented or re-indented blocks are still paired.
+func getBlockKey(path string, block Block) string {
+	header := getBlockHeader(path, block)
+	if len(block.Ancestors) == 0 {
+		return header
+	}
+
+	return block.FormatAncestors() + " > " + header
+}
+
+// getBlockHeader returns the first line of the block which is not a comment
+// or a decorator.
+func getBlockHeader(path string, block Block) string {
+	prefixes := getLeadingPrefixes(path)
+	for _, line := range block.Lines {
+		text := strings.TrimSpace(line.Text)
+		if text != "" && !hasAnyPrefix(text, prefixes) {
+			return text
+		}
+	}
+
+	return strings.TrimSpace(block.Lines[0].Text)
+}
+
+// getBlock returns the block of the second revision unless it's removed.
+func (change BlockChange) getBlock() Block {
+	if change.New != nil {
+		return *change.New
+	}
+
+	return *change.Old
+}
+
+// getLine returns the first line of the block, removed blocks are ordered
+// by lines of the first revision.
+func (change BlockChange) getLine() int {
+	return change.getBlock().GetLineStart()
+}
+
+// Diff returns the unified diff of lines of the modified block, hunks are
+// numbered by lines of files.
+func (change BlockChange) Diff() []string {
+	if change.Old == nil || change.New == nil {
+		return nil
+	}
+
+	var (
+		before = getBlockTexts(*change.Old)
+		after  = getBlockTexts(*change.New)
+
+		beforeStart = change.Old.GetLineStart()
+		afterStart  = change.New.GetLineStart()
+	)
+
+	result := []string{}
+
+	matcher := difflib.NewMatcher(before, after)
+	for _, group := range matcher.GetGroupedOpCodes(diffContext) {
+		first, last := group[0], group[len(group)-1]
+
+		result = append(result, fmt.Sprintf(
+			"@@ -%s +%s @@",
+			formatHunkRange(beforeStart+first.I1, last.I2-first.I1),
+			formatHunkRange(afterStart+first.J1, last.J2-first.J1),
+		))
+
+		for _, code := range group {
+			if code.Tag == 'e' {
+				for _, line := range before[code.I1:code.I2] {
+					result = append(result, " "+line)
+				}
+
+				continue
+			}
+
+			if code.Tag == 'r' || code.Tag == 'd' {
+				for _, line := range before[code.I1:code.I2] {
+					result = append(result, "-"+line)
+				}
+			}
+
+			if code.Tag == 'r' || code.Tag == 'i' {
+				for _, line := range after[code.J1:code.J2] {
+					result = append(result, "+"+line)
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// formatHunkRange formats lines of the hunk like diff does: the count is
+// omitted if it's 1 and the hunk without lines starts at the line before.
+func formatHunkRange(start int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	default:
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+}
+
+func getBlockTexts(block Block) []string {
+	lines := make([]string, len(block.Lines))
+	for i, line := range block.Lines {
+		lines[i] = line.Text
+	}
+
+	return lines
+}
+
+// Format returns the status, the location and the key of the block like
+// `modified server.py:12 class Server: > def run(self):`, followed by the
+// diff if the block is modified.
+func (change BlockChange) Format(useColors bool) string {
+	block := change.getBlock()
+
+	lines := []string{fmt.Sprintf(
+		"%s %s:%d %s",
+		change.Status,
+		change.Path,
+		block.GetLineStart(),
+		getBlockKey(change.Path, block),
+	)}
+
+	for _, line := range change.Diff() {
+		if useColors {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				line = ansiHunk + line + ansiReset
+			case strings.HasPrefix(line, "-"):
+				line = ansiRemoved + line + ansiReset
+			case strings.HasPrefix(line, "+"):
+				line = ansiAdded + line + ansiReset
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// EncodeJSON encodes the change, blocks are named by paths with the
+// revision prefix like files of --rev.
+func (change BlockChange) EncodeJSON(from string, to string) ([]byte, error) {
+	block := change.getBlock()
+
+	export := BlockChangeExport{
+		Status:    change.Status,
+		Filename:  change.Path,
+		Header:    getBlockHeader(change.Path, block),
+		Ancestors: block.Ancestors,
+	}
+
+	if export.Ancestors == nil {
+		export.Ancestors = []BlockLine{}
+	}
+
+	if change.Old != nil {
+		before := change.Old.export(from + archiveSeparator + change.Path)
+		export.Old = &before
+	}
+
+	if change.New != nil {
+		after := change.New.export(to + archiveSeparator + change.Path)
+		export.New = &after
+	}
+
+	if diff := change.Diff(); len(diff) > 0 {
+		export.Diff = strings.Join(diff, "\n")
+	}
+
+	return json.Marshal(export)
+}
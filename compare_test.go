@@ -0,0 +1,212 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareBlocks(t *testing.T) {
+	test := assert.New(t)
+
+	getBlock := func(start int, text string, ancestors ...string) Block {
+		lines := append(make([]string, start-1), strings.Split(text, "\n")...)
+
+		block := newBlock(lines, start-1, len(lines)-1)
+		for _, ancestor := range ancestors {
+			block.Ancestors = append(block.Ancestors, BlockLine{Text: ancestor})
+		}
+
+		return block
+	}
+
+	before := Blocks{
+		getBlock(1, "// run runs\ndef run(self):\n    pass", "class A:"),
+		getBlock(5, "def run(self):\n    pass", "class B:"),
+		getBlock(9, "def stop(self):\n    pass", "class B:"),
+		getBlock(12, "def check():\n    a\n    b"),
+		getBlock(16, "def check():\n    c"),
+	}
+
+	after := Blocks{
+		getBlock(1, "def start(self):\n    pass", "class A:"),
+		getBlock(4, "# run runs\ndef run(self):\n    pass", "class A:"),
+		getBlock(8, "def run(self):\n    return", "class B:"),
+		getBlock(12, "def check():\n    a\n    b"),
+		getBlock(16, "def check():\n    d"),
+		getBlock(20, "def check():\n    e"),
+	}
+
+	type change struct {
+		status string
+		line   int
+		key    string
+		diff   []string
+	}
+
+	changes := []change{}
+	for _, item := range compareBlocks("a.py", before, after) {
+		block := item.getBlock()
+		changes = append(changes, change{
+			status: item.Status,
+			line:   block.GetLineStart(),
+			key:    getBlockKey("a.py", block),
+			diff:   item.Diff(),
+		})
+	}
+
+	test.Equal(
+		[]change{
+			{changeAdded, 1, "class A: > def start(self):", nil},
+			{changeModified, 4, "class A: > def run(self):", []string{
+				"@@ -1,3 +4,3 @@",
+				"-// run runs",
+				"+# run runs",
+				" def run(self):",
+				"     pass",
+			}},
+			{changeModified, 8, "class B: > def run(self):", []string{
+				"@@ -5,2 +8,2 @@",
+				" def run(self):",
+				"-    pass",
+				"+    return",
+			}},
+			{changeRemoved, 9, "class B: > def stop(self):", nil},
+			{changeModified, 16, "def check():", []string{
+				"@@ -16,2 +16,2 @@",
+				" def check():",
+				"-    c",
+				"+    d",
+			}},
+			{changeAdded, 20, "def check():", nil},
+		},
+		changes,
+	)
+}
+
+func TestBlockChange_Diff(t *testing.T) {
+	test := assert.New(t)
+
+	getBlock := func(start int, lines ...string) *Block {
+		block := newBlock(append(make([]string, start-1), lines...), start-1, start+len(lines)-2)
+		return &block
+	}
+
+	change := BlockChange{
+		Status: changeModified,
+		Old:    getBlock(10, "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"),
+		New:    getBlock(20, "a", "B", "c", "d", "e", "f", "g", "h", "i", "k", "l", "m"),
+	}
+
+	test.Equal(
+		[]string{
+			"@@ -10,5 +20,5 @@",
+			" a",
+			"-b",
+			"+B",
+			" c",
+			" d",
+			" e",
+			"@@ -16,6 +26,6 @@",
+			" g",
+			" h",
+			" i",
+			"-j",
+			" k",
+			" l",
+			"+m",
+		},
+		change.Diff(),
+	)
+
+	change.New = getBlock(20, "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k")
+	test.Equal([]string{"@@ -18,4 +28,3 @@", " i", " j", " k", "-l"}, change.Diff())
+}
+
+func TestCompareRevisions(t *testing.T) {
+	test := assert.New(t)
+
+	setupTestRepository(t, map[string]string{
+		"a.go": "func foo() {\n}\n\nfunc bar() {\n}\n",
+		"b.go": "func baz() {\n}\n",
+		"c.md": "func foo() {\n}\n",
+	})
+
+	runTestGit(t, "tag", "v1")
+	runTestGit(t, "rm", "-q", "b.go")
+
+	commitTestFiles(t, map[string]string{
+		"a.go":     "func foo() {\n\treturn\n}\n\nfunc bar() {\n}\n",
+		"pkg/d.go": "func qux() {\n}\n",
+	})
+
+	changes := compareRevisions(
+		NewFileWalker([]string{"go"}),
+		"v1",
+		"HEAD",
+		[]string{"."},
+		2,
+		regexp.MustCompile(`^func`),
+		BlockOptions{},
+	)
+
+	formatted := []string{}
+	for _, change := range changes {
+		formatted = append(formatted, change.Format(false))
+	}
+
+	test.Equal(
+		[]string{
+			"modified a.go:1 func foo() {\n@@ -1,2 +1,3 @@\n func foo() {\n+\treturn\n }",
+			"removed b.go:1 func baz() {",
+			"added pkg/d.go:1 func qux() {",
+		},
+		formatted,
+	)
+
+	encoded, err := changes[1].EncodeJSON("v1", "HEAD")
+	test.NoError(err)
+	test.Contains(string(encoded), `"status":"removed","filename":"b.go","header":"func baz() {"`)
+	test.Contains(string(encoded), `"old":{"filename":"v1:b.go"`)
+	test.Contains(string(encoded), `"new":null`)
+}
+
+func TestCompareRevisions_NextFunctionRenamed(t *testing.T) {
+	test := assert.New(t)
+
+	setupTestRepository(t, map[string]string{
+		"a.py": "def foo():\n    a\n\ndef bar():\n    b\n",
+	})
+
+	runTestGit(t, "tag", "v1")
+
+	commitTestFiles(t, map[string]string{
+		"a.py": "def foo():\n    a\n\ndef baz():\n    b\n",
+	})
+
+	changes := compareRevisions(
+		NewFileWalker([]string{"py"}),
+		"v1",
+		"HEAD",
+		[]string{"."},
+		1,
+		regexp.MustCompile(`^def`),
+		BlockOptions{},
+	)
+
+	formatted := []string{}
+	for _, change := range changes {
+		formatted = append(formatted, change.Format(false))
+	}
+
+	// def foo() isn't changed, the following function is renamed
+	test.Equal(
+		[]string{
+			"added a.py:4 def baz():",
+			"removed a.py:4 def bar():",
+		},
+		formatted,
+	)
+}
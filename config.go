@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/reconquest/karma-go"
+	"golang.org/x/text/encoding"
+)
+
+// Config holds defaults read from the configuration file, command line
+// options take precedence over them.
+//
+// The file consists of key = value lines:
+//
+//	# comment
+//	tab_width = 4
+//	encoding = windows-1251
+type Config struct {
+	TabWidth int
+
+	// Encoding decodes files which are not valid UTF-8.
+	Encoding encoding.Encoding
+}
+
+// getDefaultConfigPath returns path to the configuration file in the user
+// configuration directory, usually ~/.config/blocksearch/config.
+func getDefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "blocksearch", "config")
+}
+
+// loadConfig reads the configuration file, a missing file at the default
+// location is not an error.
+func loadConfig(path string) (Config, error) {
+	var config Config
+
+	if path == "" {
+		path = getDefaultConfigPath()
+		if path == "" {
+			return config, nil
+		}
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return config, nil
+		}
+	}
+
+	err := parseINI(path, func(section, key, value string) error {
+		switch key {
+		case "tab_width":
+			width, err := strconv.Atoi(value)
+			if err != nil || width <= 0 {
+				return fmt.Errorf("tab_width should be a positive number: %q", value)
+			}
+
+			config.TabWidth = width
+
+		case "encoding":
+			fallback, err := parseEncoding(value)
+			if err != nil {
+				return err
+			}
+
+			config.Encoding = fallback
+
+		default:
+			return fmt.Errorf("unknown option: %q", key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return config, karma.Format(err, "load config %s", path)
+	}
+
+	return config, nil
+}
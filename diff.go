@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+)
+
+// lineRange is an inclusive range of line numbers starting from 1.
+type lineRange struct {
+	start int
+	end   int
+}
+
+// Diff holds lines changed by a git diff, only blocks containing them are
+// searched.
+type Diff struct {
+	// files are changed lines by absolute paths of files
+	files map[string][]lineRange
+}
+
+// loadDiff returns lines of the working tree changed since the base
+// revision, lines of the index are compared if staged is true. The base
+// defaults to HEAD, untracked files are changed entirely unless staged is
+// true.
+func loadDiff(base string, staged bool) (*Diff, error) {
+	output, err := runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+
+	top, err := filepath.EvalSymlinks(strings.TrimSpace(string(output)))
+	if err != nil {
+		return nil, karma.Format(err, "resolve top of the repository")
+	}
+
+	args := []string{
+		"diff", "-U0", "--no-color", "--no-ext-diff",
+		"--src-prefix=a/", "--dst-prefix=b/",
+	}
+
+	if staged {
+		args = append(args, "--cached")
+	}
+
+	if base != "" {
+		args = append(args, base)
+	}
+
+	// paths of the diff are relative to the top of the repository
+	output, err = runGit(append([]string{"-C", top}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{files: map[string][]lineRange{}}
+
+	changes, err := parseDiff(output)
+	if err != nil {
+		return nil, err
+	}
+
+	for path, ranges := range changes {
+		diff.files[filepath.Join(top, path)] = ranges
+	}
+
+	if !staged {
+		output, err := runGit("-C", top, "ls-files", "--others", "--exclude-standard", "-z")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range splitNull(output) {
+			diff.files[filepath.Join(top, path)] = []lineRange{{1, math.MaxInt}}
+		}
+	}
+
+	log.Debugf(nil, "changed files: %d", len(diff.files))
+
+	return diff, nil
+}
+
+// parseDiff returns added and modified lines of every file of the unified
+// diff, removed lines are not counted since they don't exist anymore.
+func parseDiff(output []byte) (map[string][]lineRange, error) {
+	var (
+		result = map[string][]lineRange{}
+		path   string
+
+		// removed and added are numbers of lines of the current hunk left
+		// to read, so lines of the hunk like `+++ x` aren't taken for
+		// headers
+		removed int
+		added   int
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, defaultMaxLineLength)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if removed > 0 || added > 0 {
+			switch {
+			case strings.HasPrefix(line, "-"):
+				removed--
+			case strings.HasPrefix(line, "+"):
+				added--
+			case line == "" || strings.HasPrefix(line, " "):
+				removed--
+				added--
+			}
+
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			// git appends a tab to paths with spaces, so the end of the path
+			// is clear for patch
+			path = strings.TrimSuffix(strings.TrimPrefix(line, "+++ "), "\t")
+
+			// paths with special characters are quoted
+			if strings.HasPrefix(path, `"`) {
+				unquoted, err := strconv.Unquote(path)
+				if err != nil {
+					return nil, fmt.Errorf("unexpected path in git diff: %q", path)
+				}
+
+				path = unquoted
+			}
+
+			// deleted files have no lines
+			if path == "/dev/null" {
+				path = ""
+				continue
+			}
+
+			path = strings.TrimPrefix(path, "b/")
+
+		case strings.HasPrefix(line, "@@ "):
+			old, changed, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+
+			removed = old.end - old.start + 1
+			added = changed.end - changed.start + 1
+
+			if path != "" && added > 0 {
+				result[path] = append(result[path], changed)
+			}
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// parseHunkHeader returns lines of the old and the new file covered by the
+// hunk with the header like "@@ -10,2 +12,3 @@", the range is empty if
+// lines are only added or removed.
+func parseHunkHeader(header string) (lineRange, lineRange, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return lineRange{}, lineRange{}, fmt.Errorf("unexpected hunk in git diff: %q", header)
+	}
+
+	old, err := parseHunkRange(fields[1], "-")
+	if err != nil {
+		return lineRange{}, lineRange{}, fmt.Errorf("unexpected hunk in git diff: %q", header)
+	}
+
+	changed, err := parseHunkRange(fields[2], "+")
+	if err != nil {
+		return lineRange{}, lineRange{}, fmt.Errorf("unexpected hunk in git diff: %q", header)
+	}
+
+	return old, changed, nil
+}
+
+// parseHunkRange parses the range of the hunk header like "+12,3", the
+// count defaults to 1.
+func parseHunkRange(field string, sign string) (lineRange, error) {
+	if !strings.HasPrefix(field, sign) {
+		return lineRange{}, fmt.Errorf("expected %q: %q", sign, field)
+	}
+
+	startValue, countValue, found := strings.Cut(field[1:], ",")
+
+	start, err := strconv.Atoi(startValue)
+	if err != nil {
+		return lineRange{}, err
+	}
+
+	count := 1
+	if found {
+		count, err = strconv.Atoi(countValue)
+		if err != nil {
+			return lineRange{}, err
+		}
+	}
+
+	return lineRange{start: start, end: start + count - 1}, nil
+}
+
+// Has reports whether the file has changed lines.
+func (diff *Diff) Has(path string) bool {
+	return len(diff.get(path)) > 0
+}
+
+// Filter returns blocks containing changed lines of the file, lines of
+// truncated blocks dropped by BlockOptions.MaxBlockLines are counted too.
+func (diff *Diff) Filter(path string, blocks Blocks) Blocks {
+	ranges := diff.get(path)
+
+	result := Blocks{}
+	for _, block := range blocks {
+		start, end := block.GetLineStart(), block.GetLineEnd()+block.Truncated
+		for _, changed := range ranges {
+			if changed.start <= end && start <= changed.end {
+				result = append(result, block)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+func (diff *Diff) get(path string) []lineRange {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+
+	// files are keyed by paths with symlinks resolved, while the working
+	// directory may be reached through a symlink
+	dir, err := filepath.EvalSymlinks(filepath.Dir(absolute))
+	if err != nil {
+		return nil
+	}
+
+	return diff.files[filepath.Join(dir, filepath.Base(absolute))]
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+)
+
+type editorConfigSection struct {
+	pattern    *regexp.Regexp
+	properties map[string]string
+}
+
+type editorConfigFile struct {
+	modTime  time.Time
+	root     bool
+	sections []editorConfigSection
+}
+
+// editorConfigs caches parsed .editorconfig files by directory, entries are
+// reloaded when the file is modified.
+var editorConfigs = struct {
+	sync.Mutex
+	files map[string]*editorConfigFile
+}{
+	files: map[string]*editorConfigFile{},
+}
+
+// getEditorConfigProperties returns properties of .editorconfig files that
+// apply to the given file, the closest file wins.
+func getEditorConfigProperties(filename string) map[string]string {
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+
+	var (
+		path       = filepath.ToSlash(absolute)
+		dir        = filepath.Dir(absolute)
+		properties = map[string]string{}
+	)
+
+	for {
+		config := loadEditorConfig(dir)
+		if config != nil {
+			relative := strings.TrimPrefix(
+				path,
+				strings.TrimSuffix(filepath.ToSlash(dir), "/")+"/",
+			)
+
+			// sections of the closer file are applied later, so go from the
+			// last section of this file to the first one and don't override
+			// what is already set
+			for i := len(config.sections) - 1; i >= 0; i-- {
+				section := config.sections[i]
+				if !section.pattern.MatchString(relative) {
+					continue
+				}
+
+				for key, value := range section.properties {
+					if _, ok := properties[key]; !ok {
+						properties[key] = value
+					}
+				}
+			}
+
+			if config.root {
+				break
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+
+		dir = parent
+	}
+
+	return properties
+}
+
+func loadEditorConfig(dir string) *editorConfigFile {
+	path := filepath.Join(dir, ".editorconfig")
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	editorConfigs.Lock()
+	defer editorConfigs.Unlock()
+
+	config, ok := editorConfigs.files[dir]
+	if ok && config.modTime.Equal(stat.ModTime()) {
+		return config
+	}
+
+	config, err = parseEditorConfig(path)
+	if err != nil {
+		log.Errorf(err, "parse %s", path)
+		return nil
+	}
+
+	config.modTime = stat.ModTime()
+	editorConfigs.files[dir] = config
+
+	return config
+}
+
+func parseEditorConfig(path string) (*editorConfigFile, error) {
+	config := &editorConfigFile{}
+
+	current := ""
+	err := parseINI(path, func(section, key, value string) error {
+		if section == "" {
+			if key == "root" {
+				config.root = strings.ToLower(value) == "true"
+			}
+
+			return nil
+		}
+
+		if section != current || len(config.sections) == 0 {
+			config.sections = append(config.sections, editorConfigSection{
+				pattern:    compileEditorConfigGlob(section),
+				properties: map[string]string{},
+			})
+
+			current = section
+		}
+
+		last := config.sections[len(config.sections)-1]
+		last.properties[key] = strings.ToLower(value)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// parseINI reads key = value pairs grouped by [section] headers, the handler
+// is called for every pair with lowercased key.
+func parseINI(
+	path string,
+	handle func(section, key, value string) error,
+) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return karma.Format(err, "open file")
+	}
+
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = line[1 : len(line)-1]
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return karma.
+				Describe("line", number).
+				Format(nil, "expected key = value, got: %q", line)
+		}
+
+		err := handle(
+			section,
+			strings.ToLower(strings.TrimSpace(key)),
+			strings.TrimSpace(value),
+		)
+		if err != nil {
+			return karma.Describe("line", number).Reason(err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+var editorConfigRange = regexp.MustCompile(`^\{(-?\d+)\.\.(-?\d+)\}`)
+
+// compileEditorConfigGlob converts section name of .editorconfig into regexp
+// matching paths relative to the directory of .editorconfig.
+func compileEditorConfigGlob(glob string) *regexp.Regexp {
+	var pattern strings.Builder
+
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+		pattern.WriteString("^")
+	} else {
+		pattern.WriteString("^(?:.*/)?")
+	}
+
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		char := glob[i]
+		switch {
+		case char == '\\' && i+1 < len(glob):
+			i++
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+
+		case strings.HasPrefix(glob[i:], "**"):
+			pattern.WriteString(".*")
+			i++
+
+		case char == '*':
+			pattern.WriteString("[^/]*")
+
+		case char == '?':
+			pattern.WriteString("[^/]")
+
+		case char == '[' && strings.IndexByte(glob[i:], ']') > 0:
+			end := i + strings.IndexByte(glob[i:], ']')
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			pattern.WriteString("[" + class + "]")
+			i = end
+
+		case editorConfigRange.MatchString(glob[i:]):
+			match := editorConfigRange.FindStringSubmatch(glob[i:])
+			from, _ := strconv.Atoi(match[1])
+			to, _ := strconv.Atoi(match[2])
+
+			numbers := []string{}
+			for number := from; number <= to && len(numbers) < 1000; number++ {
+				numbers = append(numbers, strconv.Itoa(number))
+			}
+
+			pattern.WriteString("(?:" + strings.Join(numbers, "|") + ")")
+			i += len(match[0]) - 1
+
+		case char == '{' && strings.IndexByte(glob[i:], '}') > 0:
+			braces++
+			pattern.WriteString("(?:")
+
+		case char == ',' && braces > 0:
+			pattern.WriteString("|")
+
+		case char == '}' && braces > 0:
+			braces--
+			pattern.WriteString(")")
+
+		default:
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	pattern.WriteString("$")
+
+	compiled, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return regexp.MustCompile(`^$.`)
+	}
+
+	return compiled
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileEditorConfigGlob(t *testing.T) {
+	test := assert.New(t)
+
+	testcases := []struct {
+		glob    string
+		path    string
+		matched bool
+	}{
+		{"*", "a.go", true},
+		{"*", "a/b/c.go", true},
+		{"*.go", "a.go", true},
+		{"*.go", "a/b/c.go", true},
+		{"*.go", "a.gox", false},
+		{"a/*.go", "a/b.go", true},
+		{"a/*.go", "a/b/c.go", false},
+		{"a/*.go", "c/a/b.go", false},
+		{"/a/*.go", "a/b.go", true},
+		{"a/**.go", "a/b/c.go", true},
+		{"**/b.go", "a/c/b.go", true},
+		{"a?.go", "ab.go", true},
+		{"a?.go", "a/.go", false},
+		{"*.{js,ts}", "a.ts", true},
+		{"*.{js,ts}", "a.js", true},
+		{"*.{js,ts}", "a.go", false},
+		{"{a,b/c}.go", "b/c.go", true},
+		{"file{1..3}.txt", "file2.txt", true},
+		{"file{1..3}.txt", "file4.txt", false},
+		{"file{-1..1}.txt", "file-1.txt", true},
+		{"[ab].go", "b.go", true},
+		{"[ab].go", "c.go", false},
+		{"[!ab].go", "c.go", true},
+		{"[!ab].go", "a.go", false},
+		{`\*.go`, "*.go", true},
+		{`\*.go`, "a.go", false},
+		{"a.go", "ba.go", false},
+	}
+
+	for _, testcase := range testcases {
+		test.Equal(
+			testcase.matched,
+			compileEditorConfigGlob(testcase.glob).MatchString(testcase.path),
+			"%s %s",
+			testcase.glob,
+			testcase.path,
+		)
+	}
+}
+
+func TestParseEditorConfig(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, ".editorconfig", `# comment
+root = TRUE
+
+[*]
+Indent_Style = Space
+; comment
+indent_size = 4
+
+[*.go]
+indent_style = tab
+`)
+
+	config, err := parseEditorConfig(path)
+	test.NoError(err)
+	test.True(config.root)
+	test.Len(config.sections, 2)
+	test.Equal(
+		map[string]string{"indent_style": "space", "indent_size": "4"},
+		config.sections[0].properties,
+	)
+	test.Equal(
+		map[string]string{"indent_style": "tab"},
+		config.sections[1].properties,
+	)
+
+	path = writeTestFile(t, ".editorconfig", "[*]\nindent_size\n")
+
+	_, err = parseEditorConfig(path)
+	test.Error(err)
+}
+
+func TestGetEditorConfigProperties(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		".editorconfig":     "root = true\n\n[*]\ntab_width = 3\n",
+		"a/.editorconfig":   "root = true\n\n[*]\nindent_size = 2\nindent_style = space\n",
+		"a/b/.editorconfig": "[*.go]\nindent_size = 8\n\n[b/*.go]\nindent_size = 3\n\n[*.go]\nindent_style = tab\n",
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		test.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		test.NoError(os.WriteFile(path, []byte(contents), 0644))
+	}
+
+	// root = true of a/.editorconfig stops the walk, so tab_width of the
+	// top file doesn't apply
+	test.Equal(
+		map[string]string{"indent_size": "2", "indent_style": "space"},
+		getEditorConfigProperties(filepath.Join(dir, "a/x.go")),
+	)
+
+	// the nearer file overrides the farther one, the later section
+	// overrides the earlier one
+	test.Equal(
+		map[string]string{"indent_size": "8", "indent_style": "tab"},
+		getEditorConfigProperties(filepath.Join(dir, "a/b/x.go")),
+	)
+	test.Equal(
+		map[string]string{"indent_size": "3", "indent_style": "tab"},
+		getEditorConfigProperties(filepath.Join(dir, "a/b/b/x.go")),
+	)
+	test.Equal(
+		map[string]string{"indent_size": "2", "indent_style": "space"},
+		getEditorConfigProperties(filepath.Join(dir, "a/b/x.py")),
+	)
+
+	test.Equal(
+		map[string]string{"tab_width": "3"},
+		getEditorConfigProperties(filepath.Join(dir, "x.go")),
+	)
+}
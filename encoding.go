@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// textHeaderSize is the number of bytes inspected to tell text from binary
+// files, the same amount as git looks at.
+const textHeaderSize = 8000
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF32LE = []byte{0xFF, 0xFE, 0x00, 0x00}
+	bomUTF32BE = []byte{0x00, 0x00, 0xFE, 0xFF}
+)
+
+// textEncoding is the result of looking at the file header.
+type textEncoding struct {
+	// Name is a human readable name of the encoding for verbose output.
+	Name string
+
+	// BOM is the length of the byte order mark which is skipped.
+	BOM int
+
+	// Decoder transcodes contents to UTF-8, nil if they are UTF-8 already.
+	Decoder *encoding.Decoder
+}
+
+// parseEncoding returns the legacy encoding by its name like latin1,
+// windows-1251 or shift_jis, nil for an empty name.
+func parseEncoding(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	result, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding: %q", name)
+	}
+
+	return result, nil
+}
+
+// getEncodingName returns the canonical name of the legacy encoding, empty
+// for nil.
+func getEncodingName(fallback encoding.Encoding) string {
+	if fallback == nil {
+		return ""
+	}
+
+	name, err := htmlindex.Name(fallback)
+	if err != nil {
+		return fmt.Sprint(fallback)
+	}
+
+	return name
+}
+
+// detectEncoding tells the encoding of the file by its header, the reason
+// is returned instead if the file doesn't look like text. Contents which
+// are not valid UTF-8 are decoded using the fallback encoding if it's set.
+// The header is the whole file if complete is true.
+func detectEncoding(
+	header []byte,
+	complete bool,
+	fallback encoding.Encoding,
+) (*textEncoding, string) {
+	switch {
+	case bytes.HasPrefix(header, bomUTF8):
+		return &textEncoding{Name: "UTF-8", BOM: len(bomUTF8)}, ""
+
+	case bytes.HasPrefix(header, bomUTF32LE), bytes.HasPrefix(header, bomUTF32BE):
+		return nil, "UTF-32 is not supported"
+
+	case bytes.HasPrefix(header, bomUTF16LE):
+		return newUTF16Encoding(unicode.LittleEndian, len(bomUTF16LE)), ""
+
+	case bytes.HasPrefix(header, bomUTF16BE):
+		return newUTF16Encoding(unicode.BigEndian, len(bomUTF16BE)), ""
+	}
+
+	if bytes.IndexByte(header, 0) >= 0 {
+		// UTF-16 without BOM has NUL in every other byte of ASCII text
+		if endianness, ok := guessUTF16(header); ok {
+			return newUTF16Encoding(endianness, 0), ""
+		}
+
+		return nil, "binary, contains NUL bytes"
+	}
+
+	if isValidUTF8(header, complete) {
+		return &textEncoding{Name: "UTF-8"}, ""
+	}
+
+	// the file is searched as is, like it was done before encodings were
+	// supported
+	if fallback == nil {
+		return &textEncoding{Name: "invalid UTF-8 searched as is"}, ""
+	}
+
+	return &textEncoding{
+		Name:    getEncodingName(fallback),
+		Decoder: fallback.NewDecoder(),
+	}, ""
+}
+
+func newUTF16Encoding(endianness unicode.Endianness, bom int) *textEncoding {
+	name := "UTF-16LE"
+	if endianness == unicode.BigEndian {
+		name = "UTF-16BE"
+	}
+
+	return &textEncoding{
+		Name:    name,
+		BOM:     bom,
+		Decoder: unicode.UTF16(endianness, unicode.IgnoreBOM).NewDecoder(),
+	}
+}
+
+// guessUTF16 reports whether NUL bytes are placed like in UTF-16 encoded
+// text with mostly ASCII characters: most of bytes at either odd or even
+// positions are NUL, and none at the other ones.
+func guessUTF16(header []byte) (unicode.Endianness, bool) {
+	if len(header) < 2 {
+		return unicode.LittleEndian, false
+	}
+
+	var even, odd int
+	for i, char := range header {
+		if char != 0 {
+			continue
+		}
+
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+
+	pairs := len(header) / 2
+
+	switch {
+	case even == 0 && odd*2 > pairs:
+		return unicode.LittleEndian, true
+	case odd == 0 && even*2 > pairs:
+		return unicode.BigEndian, true
+	default:
+		return unicode.LittleEndian, false
+	}
+}
+
+// isValidUTF8 checks the header ignoring the last character which might be
+// cut in the middle unless the header is complete.
+func isValidUTF8(header []byte, complete bool) bool {
+	for i := len(header) - 1; !complete && i >= 0 && i >= len(header)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(header[i]) {
+			if !utf8.FullRune(header[i:]) {
+				header = header[:i]
+			}
+
+			break
+		}
+	}
+
+	return utf8.Valid(header)
+}
+
+// textFile is contents of the file decoded to UTF-8.
+type textFile struct {
+	io.Reader
+	io.Closer
+
+	// Size is the size of the file before decoding, -1 if it's unknown like
+	// for pipes and decompressed streams.
+	Size int64
+}
+
+// openTextFile opens the file and returns reader of its contents decoded to
+// UTF-8, nil is returned for files which don't look like text. The header
+// is peeked instead of seeking back, so pipes like /dev/stdin work as well.
+func openTextFile(file File, fallback encoding.Encoding) (*textFile, error) {
+	input, size, err := file.open()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, detected, reason, err := newTextReader(input, fallback)
+	if err != nil {
+		input.Close()
+		return nil, err
+	}
+
+	if reader == nil {
+		log.Debugf(nil, "skip %s: %s", file.Path, reason)
+
+		input.Close()
+		return nil, nil
+	}
+
+	log.Debugf(nil, "encoding of %s: %s", file.Path, detected.Name)
+
+	return &textFile{Reader: reader, Closer: input, Size: size}, nil
+}
+
+// newTextReader wraps the input into reader of its contents decoded to
+// UTF-8, nil reader and the reason are returned for binary input.
+func newTextReader(
+	input io.Reader,
+	fallback encoding.Encoding,
+) (io.Reader, *textEncoding, string, error) {
+	reader := bufio.NewReaderSize(input, textHeaderSize)
+
+	header, err := reader.Peek(textHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, nil, "", karma.Format(err, "read header")
+	}
+
+	detected, reason := detectEncoding(header, err == io.EOF, fallback)
+	if detected == nil {
+		return nil, nil, reason, nil
+	}
+
+	_, err = reader.Discard(detected.BOM)
+	if err != nil {
+		return nil, nil, "", karma.Format(err, "skip byte order mark")
+	}
+
+	if detected.Decoder == nil {
+		return reader, detected, "", nil
+	}
+
+	return detected.Decoder.Reader(reader), detected, "", nil
+}
+
+// readTextFile reads contents of the file decoded to UTF-8, nil is returned
+// for files which don't look like text.
+func readTextFile(filename string, fallback encoding.Encoding) ([]byte, error) {
+	text, err := openTextFile(File{Path: filename}, fallback)
+	if err != nil || text == nil {
+		return nil, err
+	}
+
+	defer text.Close()
+
+	contents, err := io.ReadAll(text)
+	if err != nil {
+		return nil, karma.Format(err, "read file")
+	}
+
+	return contents, nil
+}
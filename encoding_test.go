@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	test := assert.New(t)
+
+	utf16le, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).
+		NewEncoder().Bytes([]byte("func main() {\n}\n"))
+	test.NoError(err)
+
+	utf16be, err := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).
+		NewEncoder().Bytes([]byte("func main() {\n}\n"))
+	test.NoError(err)
+
+	testcases := []struct {
+		name     string
+		header   []byte
+		complete bool
+		fallback bool
+		expected string
+		bom      int
+	}{
+		{"empty", []byte{}, true, false, "UTF-8", 0},
+		{"utf-8", []byte("привет"), true, false, "UTF-8", 0},
+		{"utf-8 cut", []byte("привет")[:3], false, false, "UTF-8", 0},
+		{"utf-8 bom", append(bomUTF8, "a"...), true, false, "UTF-8", 3},
+		{"utf-16le bom", append(bomUTF16LE, utf16le...), true, false, "UTF-16LE", 2},
+		{"utf-16be bom", append(bomUTF16BE, utf16be...), true, false, "UTF-16BE", 2},
+		{"utf-16le", utf16le, true, false, "UTF-16LE", 0},
+		{"utf-16be", utf16be, true, false, "UTF-16BE", 0},
+		{"utf-32", append(bomUTF32LE, 'a', 0, 0, 0), true, false, "", 0},
+		{"binary", []byte("\x7fELF\x02\x01\x01\x00\x00\x00"), true, false, "", 0},
+		{"latin1", []byte("caf\xe9"), true, false, "invalid UTF-8 searched as is", 0},
+		{"latin1 fallback", []byte("caf\xe9"), true, true, "windows-1252", 0},
+	}
+
+	for _, testcase := range testcases {
+		var fallback encoding.Encoding
+		if testcase.fallback {
+			fallback = charmap.Windows1252
+		}
+
+		detected, reason := detectEncoding(
+			testcase.header,
+			testcase.complete,
+			fallback,
+		)
+
+		if testcase.expected == "" {
+			test.Nil(detected, testcase.name)
+			test.NotEmpty(reason, testcase.name)
+			continue
+		}
+
+		if test.NotNil(detected, testcase.name) {
+			test.Equal(testcase.expected, detected.Name, testcase.name)
+			test.Equal(testcase.bom, detected.BOM, testcase.name)
+		}
+	}
+}
+
+func TestFindBlocks_Encodings(t *testing.T) {
+	test := assert.New(t)
+
+	source := "package main\n\nfunc café() {\n\treturn\n}\n"
+
+	utf16, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).
+		NewEncoder().String(source)
+	test.NoError(err)
+
+	latin1, err := charmap.Windows1252.NewEncoder().String(source)
+	test.NoError(err)
+
+	query := regexp.MustCompile(`^func café`)
+
+	for name, contents := range map[string]string{
+		"utf-8 bom": string(bomUTF8) + source,
+		"utf-16":    utf16,
+	} {
+		path := writeTestFile(t, "a.go", contents)
+
+		blocks, err := findBlocks(path, query, BlockOptions{})
+		test.NoError(err, name)
+		test.Equal([][2]int{{3, 5}}, getLineRanges(blocks), name)
+		test.Equal("func café() {", blocks[0].Lines[0].Text, name)
+	}
+
+	path := writeTestFile(t, "a.go", latin1)
+
+	blocks, err := findBlocks(path, query, BlockOptions{})
+	test.NoError(err)
+	test.Empty(blocks)
+
+	blocks, err = findBlocks(path, query, BlockOptions{
+		Encoding: charmap.Windows1252,
+	})
+	test.NoError(err)
+	test.Equal([][2]int{{3, 5}}, getLineRanges(blocks))
+
+	path = writeTestFile(t, "a.go", source+string(bytes.Repeat([]byte{0}, 4)))
+
+	blocks, err = findBlocks(path, regexp.MustCompile(`func`), BlockOptions{})
+	test.NoError(err)
+	test.Empty(blocks)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// runGit runs git with the given arguments and returns its output, stderr
+// of git is reported as the error.
+func runGit(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, karma.
+			Describe("stderr", strings.TrimSpace(stderr.String())).
+			Format(err, "git %s", strings.Join(args, " "))
+	}
+
+	return output, nil
+}
+
+// splitNull splits output of git commands called with -z.
+func splitNull(output []byte) []string {
+	result := []string{}
+	for _, item := range strings.Split(string(output), "\x00") {
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// checkRevision checks that the revision names a commit.
+func checkRevision(revision string) error {
+	_, err := runGit("rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("unknown revision: %q", revision)
+	}
+
+	return nil
+}
+
+// listTrackedFiles returns files in the git index under the given path,
+// paths are relative to the current directory like the path is.
+func listTrackedFiles(path string) ([]string, error) {
+	output, err := runGit("ls-files", "-z", "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitNull(output), nil
+}
+
+// gitObject is a file stored in the git repository.
+type gitObject struct {
+	id string
+
+	// path is the path of the file relative to the current directory,
+	// prefixed by the revision for files of revisions
+	path string
+}
+
+// listRevisionFiles returns regular files of the revision under the given
+// path, they are reported by virtual paths like release-1.4:path/to/file.go.
+func listRevisionFiles(revision string, path string) ([]gitObject, error) {
+	output, err := runGit("ls-tree", "-r", "-z", revision, "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []gitObject{}
+	for _, line := range splitNull(output) {
+		// <mode> SP <type> SP <object> TAB <path>
+		info, path, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected output of git ls-tree: %q", line)
+		}
+
+		// symlinks and submodules are skipped
+		if fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+
+		objects = append(objects, gitObject{
+			id:   fields[2],
+			path: revision + archiveSeparator + path,
+		})
+	}
+
+	return objects, nil
+}
+
+// listStagedFiles returns regular files of the git index under the given
+// path, conflicting files are skipped.
+func listStagedFiles(path string) ([]gitObject, error) {
+	output, err := runGit("ls-files", "-s", "-z", "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []gitObject{}
+	for _, line := range splitNull(output) {
+		// <mode> SP <object> SP <stage> TAB <path>
+		info, path, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected output of git ls-files: %q", line)
+		}
+
+		// symlinks, submodules and conflicts are skipped
+		if fields[0] == "120000" || fields[0] == "160000" || fields[2] != "0" {
+			continue
+		}
+
+		objects = append(objects, gitObject{id: fields[1], path: path})
+	}
+
+	return objects, nil
+}
+
+// walkObjects calls process for every object which has one of the
+// extensions, contents are read from the repository without checking them
+// out.
+func walkObjects(
+	objects []gitObject,
+	extensions []string,
+	process func(file File) error,
+) error {
+	cmd := exec.Command("git", "cat-file", "--batch")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return karma.Format(err, "git cat-file")
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return karma.Format(err, "git cat-file")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return karma.Format(err, "git cat-file")
+	}
+
+	// contents may be left unread when the search stops, so git gets
+	// EPIPE instead of waiting for them to be read
+	defer func() {
+		stdin.Close()
+		stdout.Close()
+		cmd.Wait()
+	}()
+
+	reader := bufio.NewReader(stdout)
+	for _, object := range objects {
+		if len(extensions) != 0 && !hasExtension(object.path, extensions) {
+			continue
+		}
+
+		_, err := fmt.Fprintln(stdin, object.id)
+		if err != nil {
+			return karma.Format(err, "git cat-file")
+		}
+
+		// <object> SP <type> SP <size> LF <contents> LF
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return karma.Format(err, "git cat-file")
+		}
+
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return fmt.Errorf("unexpected output of git cat-file: %q", header)
+		}
+
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected output of git cat-file: %q", header)
+		}
+
+		contents := &io.LimitedReader{R: reader, N: size}
+
+		err = processMember(object.path, size, contents, process)
+		if err != nil {
+			return err
+		}
+
+		// the rest of contents isn't read for binary files
+		_, err = io.Copy(io.Discard, contents)
+		if err != nil {
+			return karma.Format(err, "git cat-file")
+		}
+
+		_, err = reader.Discard(1)
+		if err != nil {
+			return karma.Format(err, "git cat-file")
+		}
+	}
+
+	return nil
+}
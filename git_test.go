@@ -0,0 +1,329 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupTestRepository creates a git repository with the given files
+// committed and makes it the current directory.
+func setupTestRepository(t *testing.T, files map[string]string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.Chdir(previous)
+	})
+
+	// settings of the machine like commit.gpgsign, hooks or templates
+	// must not break tests
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	runTestGit(t, "init", "-q", "--template=")
+	commitTestFiles(t, files)
+}
+
+func commitTestFiles(t *testing.T, files map[string]string) {
+	for path, contents := range files {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runTestGit(t, "add", ".")
+	runTestGit(
+		t,
+		"-c", "user.name=test",
+		"-c", "user.email=test@example.com",
+		"commit", "-q", "-m", "test",
+	)
+}
+
+func runTestGit(t *testing.T, args ...string) {
+	_, err := runGit(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileWalker_GitTracked(t *testing.T) {
+	test := assert.New(t)
+
+	setupTestRepository(t, map[string]string{
+		"a.go":     "",
+		"pkg/b.go": "",
+		"pkg/c.md": "",
+	})
+
+	test.NoError(os.WriteFile("pkg/untracked.go", nil, 0644))
+	test.NoError(os.Remove("a.go"))
+
+	walker := NewFileWalker([]string{"go"})
+	walker.GitTracked = true
+
+	files, err := walker.ListFiles(".")
+	test.NoError(err)
+	test.Equal([]string{"pkg/b.go"}, files)
+}
+
+func TestFileWalker_Revision(t *testing.T) {
+	test := assert.New(t)
+
+	setupTestRepository(t, map[string]string{
+		"a.go":     "func foo() {\n}\n",
+		"pkg/b.go": "func bar() {\n\tfoo()\n}\n",
+		"pkg/c.md": "foo\n",
+	})
+
+	runTestGit(t, "tag", "v1")
+
+	commitTestFiles(t, map[string]string{
+		"a.go": "func baz() {\n}\n",
+	})
+
+	test.Error(checkRevision("v2"))
+	test.NoError(checkRevision("v1"))
+
+	walker := NewFileWalker([]string{"go"})
+	walker.Revision = "v1"
+
+	found := map[string]Blocks{}
+	err := walker.WalkFiles(".", func(file File) error {
+		blocks, err := findFileBlocks(file, regexp.MustCompile(`foo`), BlockOptions{})
+		found[file.Path] = blocks
+		return err
+	})
+	test.NoError(err)
+	test.Equal(
+		map[string][][2]int{
+			"v1:a.go":     {{1, 2}},
+			"v1:pkg/b.go": {{2, 2}},
+		},
+		getFoundLineRanges(found),
+	)
+
+	// files on disk are not searched
+	walker.Revision = "HEAD"
+
+	found = map[string]Blocks{}
+	err = walker.WalkFiles("pkg", func(file File) error {
+		blocks, err := findFileBlocks(file, regexp.MustCompile(`ba`), BlockOptions{})
+		found[file.Path] = blocks
+		return err
+	})
+	test.NoError(err)
+	test.Equal(
+		map[string][][2]int{
+			"HEAD:pkg/b.go": {{1, 3}},
+		},
+		getFoundLineRanges(found),
+	)
+}
+
+func TestParseDiff(t *testing.T) {
+	test := assert.New(t)
+
+	// output of git diff -U0, git appends a tab to paths with spaces
+	changes, err := parseDiff([]byte(`diff --git a/a b.py b/a b.py
+index 5998231..338cbd5 100644
+--- a/a b.py` + "\t" + `
++++ b/a b.py` + "\t" + `
+@@ -2 +2 @@ def f():
+-    x
++    x = 1
+diff --git a/c.py b/c.py
+index b60fb5a..dcbee50 100644
+--- a/c.py
++++ b/c.py
+@@ -2 +1,0 @@ def g():
+-    pass
+@@ -4,0 +4 @@ def g():
++    w
+diff --git a/old.py b/old.py
+deleted file mode 100644
+index 422c2b7..0000000
+--- a/old.py
++++ /dev/null
+@@ -1,2 +0,0 @@
+-a
+-b
+diff --git a/d.sql b/d.sql
+index 9a1f2c3..5be0d41 100644
+--- a/d.sql
++++ b/d.sql
+@@ -3 +3,2 @@ select
+--- removed comment
++++ added
++x
+@@ -9,0 +11 @@ select
++y
+diff --git "a/\303\274.py" "b/\303\274.py"
+index 587be6b..b77b4eb 100644
+--- "a/\303\274.py"
++++ "b/\303\274.py"
+@@ -1,0 +2 @@ x
++y
+`))
+	test.NoError(err)
+	test.Equal(
+		map[string][]lineRange{
+			"a b.py": {{2, 2}},
+			"c.py":   {{4, 4}},
+			"d.sql":  {{3, 4}, {11, 11}},
+			"ü.py":   {{2, 2}},
+		},
+		changes,
+	)
+
+	_, err = parseDiff([]byte("+++ b/a.go\n@@ -1 +x @@\n"))
+	test.Error(err)
+}
+
+func TestLoadDiff(t *testing.T) {
+	test := assert.New(t)
+
+	setupTestRepository(t, map[string]string{
+		"a.go": "func foo() {\n\tbar()\n}\n\nfunc baz() {\n\tbar()\n}\n",
+		"b.go": "func bar() {\n}\n",
+	})
+
+	runTestGit(t, "tag", "base")
+
+	commitTestFiles(t, map[string]string{
+		"b.go": "func bar() {\n\treturn\n}\n",
+	})
+
+	test.NoError(os.WriteFile(
+		"a.go",
+		[]byte("func foo() {\n\tbar()\n}\n\nfunc baz() {\n\tbar(1)\n}\n"),
+		0644,
+	))
+	test.NoError(os.WriteFile("c.go", []byte("func qux() {\n\tbar()\n}\n"), 0644))
+
+	query := regexp.MustCompile(`^func`)
+
+	search := func(walker *FileWalker) map[string][][2]int {
+		found := map[string]Blocks{}
+		err := walker.WalkFiles(".", func(file File) error {
+			blocks, err := findFileBlocks(file, query, BlockOptions{})
+			found[file.Path] = walker.Diff.Filter(file.Path, blocks)
+			return err
+		})
+		test.NoError(err)
+
+		return getFoundLineRanges(found)
+	}
+
+	walker := NewFileWalker([]string{"go"})
+
+	walker.Diff, _ = loadDiff("", false)
+	test.Equal(
+		map[string][][2]int{
+			"a.go": {{5, 7}},
+			"c.go": {{1, 3}},
+		},
+		search(walker),
+	)
+
+	walker.Diff, _ = loadDiff("base", false)
+	test.Equal(
+		map[string][][2]int{
+			"a.go": {{5, 7}},
+			"b.go": {{1, 3}},
+			"c.go": {{1, 3}},
+		},
+		search(walker),
+	)
+
+	// the index is searched instead of files on disk
+	runTestGit(t, "add", "c.go")
+	test.NoError(os.WriteFile("c.go", []byte("func qux() {\n}\n"), 0644))
+
+	walker.Staged = true
+	walker.Diff, _ = loadDiff("", true)
+	test.Equal(
+		map[string][][2]int{
+			"c.go": {{1, 3}},
+		},
+		search(walker),
+	)
+}
+
+func TestLoadDiff_Symlink(t *testing.T) {
+	test := assert.New(t)
+
+	setupTestRepository(t, map[string]string{
+		"sub/a.go": "func foo() {\n}\n",
+	})
+
+	test.NoError(os.WriteFile(
+		"sub/a.go",
+		[]byte("func foo() {\n}\n\nfunc bar() {\n}\n"),
+		0644,
+	))
+
+	repository, err := os.Getwd()
+	test.NoError(err)
+
+	// the top of the repository reported by git has symlinks resolved
+	link := filepath.Join(t.TempDir(), "link")
+	test.NoError(os.Symlink(repository, link))
+	test.NoError(os.Chdir(filepath.Join(link, "sub")))
+
+	// the shell keeps the path with the symlink in PWD, so it's returned by
+	// os.Getwd
+	t.Setenv("PWD", filepath.Join(link, "sub"))
+
+	diff, err := loadDiff("", false)
+	test.NoError(err)
+	test.True(diff.Has("a.go"))
+
+	blocks, err := findBlocks("a.go", regexp.MustCompile(`^func`), BlockOptions{})
+	test.NoError(err)
+	test.Equal([][2]int{{4, 5}}, getLineRanges(diff.Filter("a.go", blocks)))
+}
+
+func TestDiff_Filter_Truncated(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", "func foo() {\n\ta()\n\tb()\n}\n")
+
+	resolved, err := filepath.EvalSymlinks(path)
+	test.NoError(err)
+
+	diff := &Diff{files: map[string][]lineRange{resolved: {{3, 3}}}}
+
+	// the changed line is below the cut, but still inside of the block
+	blocks, err := findBlocks(
+		path,
+		regexp.MustCompile(`^func`),
+		BlockOptions{MaxBlockLines: 2},
+	)
+	test.NoError(err)
+	test.Equal([][2]int{{1, 2}}, getLineRanges(diff.Filter(path, blocks)))
+}
@@ -6,13 +6,15 @@ require (
 	github.com/alecthomas/chroma v0.10.0
 	github.com/benhoyt/goawk v1.25.0
 	github.com/docopt/docopt-go v0.0.0-20180111231733-ee0de3bc6815
+	github.com/klauspost/compress v1.18.0
 	github.com/kovetskiy/lorg v1.2.0
 	github.com/mark3labs/mcp-go v0.43.2
 	github.com/mattn/go-isatty v0.0.20
-	github.com/monochromegane/go-gitignore v0.0.0-20200626010858-205db1a8cc00
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/reconquest/karma-go v1.2.0
 	github.com/reconquest/pkg v1.3.0
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/text v0.22.0
 )
 
 require (
@@ -23,7 +25,6 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/invopop/jsonschema v0.13.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/reconquest/cog v0.0.0-20230331074503-900980efda0b // indirect
 	github.com/spf13/cast v1.7.1 // indirect
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
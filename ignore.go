@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/reconquest/pkg/log"
+)
+
+// ignoreFileNames are files with ignore patterns looked up in every
+// directory, patterns of later files take precedence.
+var ignoreFileNames = []string{".gitignore", ".ignore", ".blocksearchignore"}
+
+// ignorePattern is a single line of the ignore file.
+type ignorePattern struct {
+	regexp  *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList is patterns of a single ignore file, they are matched against
+// paths relative to dir.
+type ignoreList struct {
+	dir      string
+	patterns []ignorePattern
+}
+
+// match returns whether the path is ignored by the last pattern matching
+// it, matched is false if no pattern matches it.
+func (list *ignoreList) match(path string, dir bool) (ignored bool, matched bool) {
+	relative, ok := strings.CutPrefix(path, strings.TrimSuffix(list.dir, "/")+"/")
+	if !ok {
+		return false, false
+	}
+
+	for i := len(list.patterns) - 1; i >= 0; i-- {
+		pattern := list.patterns[i]
+		if pattern.dirOnly && !dir {
+			continue
+		}
+
+		if pattern.regexp.MatchString(relative) {
+			return !pattern.negate, true
+		}
+	}
+
+	return false, false
+}
+
+// ignoreRules are ignore lists applying to files of a directory, the same
+// way as git does: lists of the directory take precedence over lists of its
+// parent directories, which take precedence over .git/info/exclude and
+// core.excludesFile.
+type ignoreRules struct {
+	parent *ignoreRules
+	lists  []*ignoreList
+}
+
+// Match reports whether the path is ignored, the path must be absolute.
+func (rules *ignoreRules) Match(path string, dir bool) bool {
+	path = filepath.ToSlash(path)
+
+	for current := rules; current != nil; current = current.parent {
+		for i := len(current.lists) - 1; i >= 0; i-- {
+			ignored, matched := current.lists[i].match(path, dir)
+			if matched {
+				return ignored
+			}
+		}
+	}
+
+	return false
+}
+
+// push returns rules for files of the given directory, ignore files of the
+// directory are stacked on top of the current rules.
+func (rules *ignoreRules) push(dir string, names ...string) *ignoreRules {
+	lists := []*ignoreList{}
+	for _, name := range names {
+		list := loadIgnoreList(filepath.Join(dir, name), dir)
+		if list != nil {
+			lists = append(lists, list)
+		}
+	}
+
+	if len(lists) == 0 {
+		return rules
+	}
+
+	return &ignoreRules{parent: rules, lists: lists}
+}
+
+// getIgnoreRules returns rules for files of the directory which is going to
+// be walked: global excludes of git and ignore files of its parent
+// directories inside of the repository. The directory must be absolute.
+func getIgnoreRules(dir string) *ignoreRules {
+	var rules *ignoreRules
+
+	repository := findRepository(dir)
+	if repository == "" {
+		return rules.push(dir, ignoreFileNames...)
+	}
+
+	for _, path := range getExcludesFiles(repository) {
+		list := loadIgnoreList(path, repository)
+		if list != nil {
+			rules = &ignoreRules{parent: rules, lists: []*ignoreList{list}}
+		}
+	}
+
+	rules = rules.push(repository, filepath.Join(".git", "info", "exclude"))
+
+	relative, err := filepath.Rel(repository, dir)
+	if err != nil {
+		return rules.push(dir, ignoreFileNames...)
+	}
+
+	current := repository
+	rules = rules.push(current, ignoreFileNames...)
+
+	if relative != "." {
+		for _, name := range strings.Split(relative, string(filepath.Separator)) {
+			current = filepath.Join(current, name)
+			rules = rules.push(current, ignoreFileNames...)
+		}
+	}
+
+	return rules
+}
+
+// findRepository returns the top directory of the git repository containing
+// the given absolute directory, empty if there is none.
+func findRepository(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+
+		dir = parent
+	}
+}
+
+// getExcludesFiles returns global ignore files of git, core.excludesFile of
+// the repository configuration overrides the global one. ~/.gitignore_global
+// is used as well for compatibility with previous versions.
+func getExcludesFiles(repository string) []string {
+	home, _ := os.UserHomeDir()
+
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" && home != "" {
+		configDir = filepath.Join(home, ".config")
+	}
+
+	excludesFile := ""
+	if configDir != "" {
+		excludesFile = filepath.Join(configDir, "git", "ignore")
+	}
+
+	configs := []string{
+		filepath.Join(configDir, "git", "config"),
+		filepath.Join(home, ".gitconfig"),
+		filepath.Join(repository, ".git", "config"),
+	}
+
+	for _, config := range configs {
+		value := getGitConfigValue(config, "core", "excludesfile")
+		if value != "" {
+			excludesFile = value
+		}
+	}
+
+	if strings.HasPrefix(excludesFile, "~/") && home != "" {
+		excludesFile = filepath.Join(home, excludesFile[2:])
+	}
+
+	files := []string{}
+	if home != "" {
+		files = append(files, filepath.Join(home, ".gitignore_global"))
+	}
+
+	if excludesFile != "" {
+		files = append(files, excludesFile)
+	}
+
+	return files
+}
+
+// getGitConfigValue reads the value of the key from the section of the git
+// configuration file, empty if it's not set. Only plain values are
+// supported, includes are not followed.
+func getGitConfigValue(path string, section string, key string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+
+	defer file.Close()
+
+	var (
+		current string
+		result  string
+	)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			name, _, _ := strings.Cut(strings.Trim(line, "[]"), " ")
+			current = strings.ToLower(name)
+			continue
+		}
+
+		name, value, _ := strings.Cut(line, "=")
+		if current == section && strings.EqualFold(strings.TrimSpace(name), key) {
+			result = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+
+	return result
+}
+
+// loadIgnoreList reads patterns of the ignore file matched against paths
+// relative to dir, nil is returned if the file doesn't exist.
+func loadIgnoreList(path string, dir string) *ignoreList {
+	file, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf(err, "open %s", path)
+		}
+
+		return nil
+	}
+
+	defer file.Close()
+
+	list := &ignoreList{dir: filepath.ToSlash(dir)}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern, ok := compileIgnorePattern(scanner.Text())
+		if ok {
+			list.patterns = append(list.patterns, pattern)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		log.Errorf(err, "read %s", path)
+	}
+
+	return list
+}
+
+// compileIgnorePattern converts the line of the ignore file into regexp
+// matching paths relative to the directory of the file, false is returned
+// for empty lines and comments.
+func compileIgnorePattern(line string) (ignorePattern, bool) {
+	var result ignorePattern
+
+	line = strings.TrimSuffix(line, "\r")
+
+	// trailing spaces are ignored unless they are escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || line[0] == '#' {
+		return result, false
+	}
+
+	if line[0] == '!' {
+		result.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		result.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if line == "" {
+		return result, false
+	}
+
+	var pattern strings.Builder
+
+	// patterns without slashes match at any level
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+		pattern.WriteString("^")
+	} else {
+		pattern.WriteString("^(?:.*/)?")
+	}
+
+	for i := 0; i < len(line); i++ {
+		char := line[i]
+		switch {
+		case char == '\\' && i+1 < len(line):
+			i++
+			pattern.WriteString(regexp.QuoteMeta(line[i : i+1]))
+
+		case i == 0 && strings.HasPrefix(line, "**/"):
+			pattern.WriteString("(?:.*/)?")
+			i += 2
+
+		case strings.HasPrefix(line[i:], "/**/"):
+			pattern.WriteString("/(?:.*/)?")
+			i += 3
+
+		case line[i:] == "/**":
+			pattern.WriteString("/.*")
+			i += 2
+
+		case char == '*':
+			pattern.WriteString("[^/]*")
+			for i+1 < len(line) && line[i+1] == '*' {
+				i++
+			}
+
+		case char == '?':
+			pattern.WriteString("[^/]")
+
+		case char == '[' && strings.IndexByte(line[i+1:], ']') > 0:
+			end := i + 1 + strings.IndexByte(line[i+1:], ']')
+			class := line[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			pattern.WriteString("[" + class + "]")
+			i = end
+
+		default:
+			pattern.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		}
+	}
+
+	pattern.WriteString("$")
+
+	compiled, err := regexp.Compile(pattern.String())
+	if err != nil {
+		log.Debugf(nil, "invalid ignore pattern %q: %s", line, err)
+		return result, false
+	}
+
+	result.regexp = compiled
+
+	return result, true
+}
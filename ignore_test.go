@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileIgnorePattern(t *testing.T) {
+	test := assert.New(t)
+
+	testcases := []struct {
+		pattern string
+		path    string
+		dir     bool
+		ignored bool
+	}{
+		{"*.log", "a.log", false, true},
+		{"*.log", "a/b/c.log", false, true},
+		{"*.log", "a.logs", false, false},
+		{"/build", "build", true, true},
+		{"/build", "a/build", true, false},
+		{"build/", "a/build", true, true},
+		{"build/", "a/build", false, false},
+		{"a/b", "a/b", false, true},
+		{"a/b", "c/a/b", false, false},
+		{"**/b", "a/c/b", false, true},
+		{"**/b", "b", false, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**", "a/x/y", false, true},
+		{"a/**", "a", true, false},
+		{"a/*/b", "a/x/y/b", false, false},
+		{"file.[ch]", "file.c", false, true},
+		{"file.[!ch]", "file.c", false, false},
+		{"?.txt", "a.txt", false, true},
+		{"?.txt", "ab.txt", false, false},
+		{`\#file`, "#file", false, true},
+		{`\!file`, "!file", false, true},
+		{"trailing  ", "trailing", false, true},
+	}
+
+	for _, testcase := range testcases {
+		pattern, ok := compileIgnorePattern(testcase.pattern)
+		test.True(ok, testcase.pattern)
+
+		list := &ignoreList{dir: "/repo", patterns: []ignorePattern{pattern}}
+
+		ignored, _ := list.match("/repo/"+testcase.path, testcase.dir)
+		test.Equal(
+			testcase.ignored,
+			ignored,
+			"%q against %q", testcase.pattern, testcase.path,
+		)
+	}
+
+	for _, line := range []string{"", "# comment", "   ", "!"} {
+		_, ok := compileIgnorePattern(line)
+		test.False(ok, line)
+	}
+}
+
+func TestFileWalker_Ignore(t *testing.T) {
+	test := assert.New(t)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+
+	dir := t.TempDir()
+	for path, contents := range map[string]string{
+		".git/info/exclude":          "*.exclude\n",
+		".git/config":                "[core]\n\texcludesFile = ~/excludes\n",
+		".gitignore":                 "*.log\n/build/\n",
+		"a.go":                       "",
+		"a.log":                      "",
+		"a.exclude":                  "",
+		"a.global":                   "",
+		"build/a.go":                 "",
+		"pkg/.gitignore":             "!keep.log\ngenerated/\n",
+		"pkg/keep.log":               "",
+		"pkg/drop.log":               "",
+		"pkg/generated/a.go":         "",
+		"pkg/sub/.ignore":            "*.tmp\n",
+		"pkg/sub/a.tmp":              "",
+		"pkg/sub/keep.log":           "",
+		"pkg/sub/.blocksearchignore": "!*.tmp\nb.go\n",
+		"pkg/sub/b.go":               "",
+		"pkg/sub/c.go":               "",
+	} {
+		path = filepath.Join(dir, path)
+		test.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		test.NoError(os.WriteFile(path, []byte(contents), 0644))
+	}
+
+	test.NoError(os.WriteFile(filepath.Join(home, "excludes"), []byte("*.global\n"), 0644))
+
+	walker := NewFileWalker(nil)
+
+	files, err := walker.ListFiles(dir)
+	test.NoError(err)
+	test.Equal(
+		[]string{
+			".gitignore",
+			"a.go",
+			"pkg/.gitignore",
+			"pkg/keep.log",
+			"pkg/sub/.blocksearchignore",
+			"pkg/sub/.ignore",
+			"pkg/sub/a.tmp",
+			"pkg/sub/c.go",
+			"pkg/sub/keep.log",
+		},
+		getRelativePaths(t, dir, files),
+	)
+
+	// rules of parent directories apply when a subdirectory is walked
+	files, err = walker.ListFiles(filepath.Join(dir, "pkg"))
+	test.NoError(err)
+	test.Equal(
+		[]string{
+			"pkg/.gitignore",
+			"pkg/keep.log",
+			"pkg/sub/.blocksearchignore",
+			"pkg/sub/.ignore",
+			"pkg/sub/a.tmp",
+			"pkg/sub/c.go",
+			"pkg/sub/keep.log",
+		},
+		getRelativePaths(t, dir, files),
+	)
+
+	walker.NoIgnore = true
+
+	files, err = walker.ListFiles(dir)
+	test.NoError(err)
+	test.Len(files, 16)
+}
+
+func getRelativePaths(t *testing.T, dir string, paths []string) []string {
+	result := []string{}
+	for _, path := range paths {
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result = append(result, filepath.ToSlash(relative))
+	}
+
+	return result
+}
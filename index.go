@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+	"golang.org/x/text/encoding"
+)
+
+// indexDir is the directory with the index, it's never searched.
+const indexDir = ".blocksearch"
+
+// indexPath is the location of the trigram index relative to the directory
+// it's built for.
+var indexPath = filepath.Join(indexDir, "index")
+
+// Index keeps trigrams of files, so files which can't contain the literal
+// required by the query are skipped without reading them.
+type Index struct {
+	// Files are indexed by paths relative to the directory of the index.
+	Files map[string]IndexedFile
+
+	// Encoding is the name of the legacy encoding files which are not valid
+	// UTF-8 were decoded with, the index is useless for searches decoding
+	// them another way.
+	Encoding string
+}
+
+// IndexedFile is an entry of the index, it's used only while size and
+// modification time of the file are the same as during indexing.
+type IndexedFile struct {
+	Size    int64
+	ModTime int64
+
+	// Trigrams are sorted unique trigrams of the contents, binary files
+	// have none.
+	Trigrams []uint32
+}
+
+// buildIndex indexes files found by the walker in the current directory,
+// entries of the previous index are reused for unchanged files. Files
+// larger than maxFileSize are not indexed unless it's 0.
+func buildIndex(
+	walker *FileWalker,
+	previous *Index,
+	fallback encoding.Encoding,
+	maxFileSize int64,
+) (*Index, error) {
+	index := &Index{
+		Files:    map[string]IndexedFile{},
+		Encoding: getEncodingName(fallback),
+	}
+
+	if previous != nil && previous.Encoding != index.Encoding {
+		previous = nil
+	}
+
+	err := walker.Walk(".", func(path string) error {
+		stat, err := os.Stat(path)
+		if err != nil {
+			log.Errorf(err, "%s", path)
+			return nil
+		}
+
+		// huge files aren't read into memory, without an entry they are
+		// always searched
+		if stat.Size() > streamingThreshold {
+			return nil
+		}
+
+		// files skipped by --max-filesize are not searched anyway
+		if maxFileSize > 0 && stat.Size() > maxFileSize {
+			return nil
+		}
+
+		if previous != nil {
+			entry, ok := previous.Files[path]
+			if ok && entry.isFresh(stat) {
+				index.Files[path] = entry
+				return nil
+			}
+		}
+
+		contents, err := readTextFile(path, fallback)
+		if err != nil {
+			log.Errorf(err, "%s", path)
+			return nil
+		}
+
+		index.Files[path] = IndexedFile{
+			Size:     stat.Size(),
+			ModTime:  stat.ModTime().UnixNano(),
+			Trigrams: getTrigrams(contents),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// loadIndex reads the index, a missing index is not an error.
+func loadIndex(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, karma.Format(err, "open index")
+	}
+
+	defer file.Close()
+
+	var index Index
+	err = gob.NewDecoder(file).Decode(&index)
+	if err != nil {
+		return nil, karma.Format(err, "decode index %s", path)
+	}
+
+	return &index, nil
+}
+
+// Save writes the index to a temporary file which replaces the previous
+// index, so concurrent searches never read an incomplete one.
+func (index *Index) Save(path string) error {
+	dir := filepath.Dir(path)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return karma.Format(err, "create index directory")
+	}
+
+	// the index is never committed, the existing file is kept since users
+	// may have changed it
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		err = os.WriteFile(ignore, []byte("*\n"), 0644)
+		if err != nil {
+			return karma.Format(err, "write %s", ignore)
+		}
+	}
+
+	file, err := os.CreateTemp(dir, ".index")
+	if err != nil {
+		return karma.Format(err, "create index")
+	}
+
+	defer os.Remove(file.Name())
+
+	err = gob.NewEncoder(file).Encode(index)
+	if err != nil {
+		file.Close()
+		return karma.Format(err, "encode index")
+	}
+
+	err = file.Close()
+	if err != nil {
+		return karma.Format(err, "write index")
+	}
+
+	// temporary files are created readable only by the owner
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		return karma.Format(err, "chmod index")
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// mayContain returns false if the file is indexed, didn't change since then
+// and lacks some of the trigrams.
+func (index *Index) mayContain(path string, stat os.FileInfo, trigrams []uint32) bool {
+	entry, ok := index.Files[filepath.Clean(path)]
+	if !ok || !entry.isFresh(stat) {
+		return true
+	}
+
+	for _, trigram := range trigrams {
+		found := sort.Search(len(entry.Trigrams), func(i int) bool {
+			return entry.Trigrams[i] >= trigram
+		})
+
+		if found == len(entry.Trigrams) || entry.Trigrams[found] != trigram {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (entry IndexedFile) isFresh(stat os.FileInfo) bool {
+	return entry.Size == stat.Size() && entry.ModTime == stat.ModTime().UnixNano()
+}
+
+// getTrigrams returns sorted unique trigrams of the contents.
+func getTrigrams(contents []byte) []uint32 {
+	if len(contents) < 3 {
+		return nil
+	}
+
+	trigrams := make([]uint32, 0, len(contents)-2)
+	for i := 0; i+2 < len(contents); i++ {
+		trigrams = append(
+			trigrams,
+			uint32(contents[i])<<16|uint32(contents[i+1])<<8|uint32(contents[i+2]),
+		)
+	}
+
+	sort.Slice(trigrams, func(i, j int) bool {
+		return trigrams[i] < trigrams[j]
+	})
+
+	unique := trigrams[:1]
+	for _, trigram := range trigrams[1:] {
+		if trigram != unique[len(unique)-1] {
+			unique = append(unique, trigram)
+		}
+	}
+
+	// the index keeps only unique trigrams, don't hold the whole buffer
+	return append([]uint32{}, unique...)
+}
+
+// getQueryTrigrams returns trigrams of the literal required by the query,
+// nil if the literal is too short to narrow the search.
+func getQueryTrigrams(query *regexp.Regexp) []uint32 {
+	return getTrigrams([]byte(getRequiredLiteral(query)))
+}
+
+// indexCache keeps the index loaded in memory for the MCP server, it's
+// reloaded when the index file is rebuilt.
+type indexCache struct {
+	sync.Mutex
+
+	modTime time.Time
+	index   *Index
+}
+
+// Get returns the current index or nil if there is none.
+func (cache *indexCache) Get() *Index {
+	stat, err := os.Stat(indexPath)
+	if err != nil {
+		return nil
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.index != nil && cache.modTime.Equal(stat.ModTime()) {
+		return cache.index
+	}
+
+	index, err := loadIndex(indexPath)
+	if err != nil {
+		log.Errorf(err, "load index")
+		return nil
+	}
+
+	cache.index = index
+	cache.modTime = stat.ModTime()
+
+	return index
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex_MayContain(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", "func A() {\n\tpanic(1)\n}\n")
+
+	stat, err := os.Stat(path)
+	test.NoError(err)
+
+	contents, err := os.ReadFile(path)
+	test.NoError(err)
+
+	index := &Index{Files: map[string]IndexedFile{
+		path: {
+			Size:     stat.Size(),
+			ModTime:  stat.ModTime().UnixNano(),
+			Trigrams: getTrigrams(contents),
+		},
+	}}
+
+	mayContain := func(query string) bool {
+		trigrams := getQueryTrigrams(regexp.MustCompile(query))
+		return index.mayContain(path, stat, trigrams)
+	}
+
+	test.True(mayContain(`panic\(1\)`))
+	test.True(mayContain(`^func \w+\(`))
+	test.False(mayContain(`panic\(2\)`))
+	test.False(mayContain(`return nil`))
+
+	// changed files are searched regardless of the index
+	test.NoError(os.WriteFile(path, []byte("return nil\n"), 0644))
+	test.NoError(os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)))
+
+	stat, err = os.Stat(path)
+	test.NoError(err)
+	test.True(mayContain(`return nil`))
+
+	// as well as files missing in the index
+	other := filepath.Join(filepath.Dir(path), "b.go")
+	test.True(index.mayContain(other, stat, getQueryTrigrams(regexp.MustCompile(`x{3}`))))
+}
+
+func TestIndex_Save(t *testing.T) {
+	test := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), indexDir, "index")
+
+	index, err := loadIndex(path)
+	test.NoError(err)
+	test.Nil(index)
+
+	expected := &Index{Files: map[string]IndexedFile{
+		"a.go": {Size: 10, ModTime: 20, Trigrams: getTrigrams([]byte("abcabd"))},
+	}}
+
+	test.NoError(expected.Save(path))
+
+	ignore, err := os.ReadFile(filepath.Join(filepath.Dir(path), ".gitignore"))
+	test.NoError(err)
+	test.Equal("*\n", string(ignore))
+
+	index, err = loadIndex(path)
+	test.NoError(err)
+	test.Equal(expected, index)
+	test.Equal(
+		[]uint32{'a'<<16 | 'b'<<8 | 'c', 'a'<<16 | 'b'<<8 | 'd', 'b'<<16 | 'c'<<8 | 'a', 'c'<<16 | 'a'<<8 | 'b'},
+		index.Files["a.go"].Trigrams,
+	)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"strings"
+)
+
+// keywordSyntax describes keywords that open and close blocks in languages
+// like Ruby, Lua, SQL or shell.
+type keywordSyntax struct {
+	// openers open a block wherever they are found.
+	openers []string
+	// statementOpeners open a block only at the beginning of a statement, so
+	// modifiers like Ruby's `return if x` are not counted.
+	statementOpeners []string
+	// loopOpeners are statement openers which don't need loopBody keyword to
+	// open a block when it's on the same line, like Ruby's `while x do`.
+	loopOpeners []string
+	loopBody    string
+	closers     []string
+
+	lineComments []string
+	quotes       string
+	ignoreCase   bool
+}
+
+var (
+	genericKeywords = keywordSyntax{
+		openers: []string{"begin", "do"},
+		closers: []string{"end"},
+		quotes:  `"'`,
+	}
+
+	rubyKeywords = keywordSyntax{
+		openers: []string{"def", "class", "module", "begin", "do"},
+		statementOpeners: []string{
+			"if", "unless", "case", "while", "until", "for",
+		},
+		loopOpeners:  []string{"while", "until", "for"},
+		loopBody:     "do",
+		closers:      []string{"end"},
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+
+	luaKeywords = keywordSyntax{
+		openers:      []string{"function", "if", "do", "repeat"},
+		closers:      []string{"end", "until"},
+		lineComments: []string{"--"},
+		quotes:       `"'`,
+	}
+
+	sqlKeywords = keywordSyntax{
+		openers:          []string{"begin", "case", "loop"},
+		statementOpeners: []string{"if"},
+		closers:          []string{"end"},
+		lineComments:     []string{"--"},
+		quotes:           `"'`,
+		ignoreCase:       true,
+	}
+
+	shellKeywords = keywordSyntax{
+		openers:          []string{"do", "{"},
+		statementOpeners: []string{"if", "case"},
+		closers:          []string{"fi", "esac", "done", "}"},
+		lineComments:     []string{"#"},
+		quotes:           `"'`,
+	}
+)
+
+// keywordLanguages maps file extensions to the syntax used for keyword-pair
+// block termination.
+var keywordLanguages = map[string]keywordSyntax{
+	"rb":      rubyKeywords,
+	"rake":    rubyKeywords,
+	"gemspec": rubyKeywords,
+	"lua":     luaKeywords,
+	"sql":     sqlKeywords,
+	"sh":      shellKeywords,
+	"bash":    shellKeywords,
+	"zsh":     shellKeywords,
+	"ksh":     shellKeywords,
+}
+
+// KeywordStrategy terminates the block when all keyword pairs like if/fi,
+// do/end or begin/end opened in it are closed.
+type KeywordStrategy struct {
+	Syntax keywordSyntax
+
+	keywords nestingLines
+}
+
+func (strategy *KeywordStrategy) Name() string {
+	return "keyword"
+}
+
+func (strategy *KeywordStrategy) Prepare(lines []string) {
+	strategy.keywords = scanKeywords(lines, strategy.Syntax)
+}
+
+func (strategy *KeywordStrategy) GetBlockEnd(lines []string, start int) int {
+	return strategy.keywords.getBlockEnd(lines, start, nil)
+}
+
+func (strategy *KeywordStrategy) GetLevel(lines []string, index int) int {
+	return strategy.keywords.getLevel(index)
+}
+
+// scanKeywords tracks nesting of keyword pairs through the whole file,
+// skipping string literals and comments.
+func scanKeywords(lines []string, syntax keywordSyntax) nestingLines {
+	var (
+		result = make(nestingLines, len(lines))
+		depth  = 0
+	)
+
+	for index, line := range lines {
+		stat := nestingLine{start: depth, min: depth, max: depth}
+
+		var (
+			// statement is true when the next word starts a statement
+			statement = true
+			// closed is true when the previous word closed a block, so
+			// `END IF` doesn't open a new one
+			closed = false
+			// loop is true when a loop opener was found on the line
+			loop = false
+		)
+
+		for i := 0; i < len(line); {
+			char := line[i]
+
+			if (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') &&
+				hasAnyPrefix(line[i:], syntax.lineComments) {
+				break
+			}
+
+			if strings.IndexByte(syntax.quotes, char) >= 0 {
+				i = skipStringLiteral(line, i)
+				statement = false
+				closed = false
+				continue
+			}
+
+			word := getKeywordToken(line, i)
+			if word == "" {
+				switch char {
+				case ';', '=', '(', '|', '&':
+					statement = true
+				case ' ', '\t':
+				default:
+					statement = false
+					closed = false
+				}
+
+				i++
+				continue
+			}
+
+			i += len(word)
+
+			if syntax.ignoreCase {
+				word = strings.ToLower(word)
+			}
+
+			switch {
+			case isOneOf(word, syntax.closers):
+				depth--
+				stat.update(depth)
+				closed = true
+				statement = false
+				continue
+
+			case closed:
+
+			case word == syntax.loopBody && loop:
+				loop = false
+
+			case isOneOf(word, syntax.openers) ||
+				statement && isOneOf(word, syntax.statementOpeners):
+				depth++
+				stat.update(depth)
+
+				if isOneOf(word, syntax.loopOpeners) {
+					loop = true
+				}
+			}
+
+			statement = false
+			closed = false
+		}
+
+		stat.end = depth
+		result[index] = stat
+	}
+
+	return result
+}
+
+// getKeywordToken returns the word which starts at the given position or a
+// standalone curly brace.
+func getKeywordToken(line string, start int) string {
+	char := line[start]
+
+	if char == '{' || char == '}' {
+		before := start == 0 || isSpaceOrSemicolon(line[start-1])
+		after := start+1 == len(line) || isSpaceOrSemicolon(line[start+1])
+		if before && after {
+			return line[start : start+1]
+		}
+
+		return ""
+	}
+
+	if !isWordStart(char) {
+		return ""
+	}
+
+	if start > 0 {
+		switch previous := line[start-1]; {
+		case isWordChar(previous), previous == '.', previous == ':',
+			previous == '$', previous == '@':
+			return ""
+		}
+	}
+
+	end := start + 1
+	for end < len(line) && isWordChar(line[end]) {
+		end++
+	}
+
+	if end < len(line) {
+		switch line[end] {
+		case '?', '!', ':':
+			return ""
+		}
+	}
+
+	return line[start:end]
+}
+
+func isSpaceOrSemicolon(char byte) bool {
+	return char == ' ' || char == '\t' || char == ';'
+}
+
+func isWordStart(char byte) bool {
+	return char == '_' ||
+		(char >= 'a' && char <= 'z') ||
+		(char >= 'A' && char <= 'Z')
+}
+
+func isWordChar(char byte) bool {
+	return isWordStart(char) || (char >= '0' && char <= '9')
+}
+
+func isOneOf(word string, words []string) bool {
+	for _, item := range words {
+		if word == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -8,8 +8,9 @@ import (
 	"strings"
 
 	"github.com/kovetskiy/lorg"
-	"github.com/monochromegane/go-gitignore"
+	"github.com/reconquest/karma-go"
 	"github.com/reconquest/pkg/log"
+	"golang.org/x/text/encoding"
 
 	"github.com/docopt/docopt-go"
 
@@ -21,23 +22,49 @@ var (
 	usage   = "blocksearch " + version + `
 
 Usage:
-  blocksearch [options] <query> [<file>...] [-a <if>]... [-x <ext>]...
+  blocksearch index (build | update) [options] [-x <ext>]...
+  blocksearch diff [options] <rev1> <rev2> <query> [<file>...] [-x <ext>]... [--contains <re>]... [--not-contains <re>]...
+  blocksearch [options] [--] <query> [<file>...] [-a <if>]... [-x <ext>]... [--contains <re>]... [--not-contains <re>]...
   blocksearch -M [--workdir <dir>]
   blocksearch -h | --help
   blocksearch --version
 
 Options:
   -i <n>                 Show lines higher than current indentation level plus <n> (can be negative).
+  -s --strategy <name>   Block strategy: auto, indent, brace, keyword, tag or heading. [default: auto]
+  -u --up <n>            Return the block enclosing the match <n> levels up, "top" for the top level one.
+  --overlap <policy>     Matches inside found blocks: skip, merge or nested. [default: skip]
+  -m --multiline         Match the query against the whole file, not line by line.
+  -d --docs              Include leading comments and decorators/annotations.
+  --tab-width <n>        Number of columns a tab advances to, overrides .editorconfig.
+  --max-line-length <n>  Maximum length of lines in huge files read line by line (default: 1048576).
+  --max-filesize <size>  Skip files larger than the size, like 10M.
+  --max-block-lines <n>  Truncate blocks longer than <n> lines.
+  --max-blocks <n>       Stop after <n> blocks.
+  --encoding <name>      Decode files which are not valid UTF-8, like latin1 or windows-1251.
+  --config <path>        Configuration file (default: ~/.config/blocksearch/config).
   -t --file              Show filename before the line.
   -l --no-line           Do not show number of line before the line.
   -c --no-colors         Do not use colors for syntax highlighting.
   -j --json              Output blocks in JSON.
+  -J --jobs <n>          Number of files searched concurrently (default: number of CPUs).
   -S --stream <path>     Stream and execute the given program. Enforces JSON.
   -a --awk <if>          Filter blocks by specified AWK condition.
+  --awk-mode <mode>      Combine several AWK conditions: any, all or none. [default: any]
+  --awk-print <program>  Replace output of blocks by output of the AWK program.
+  --contains <re>        Filter blocks containing the specified regexp, all of them must match.
+  --not-contains <re>    Filter blocks not containing the specified regexp.
   -e --exit-code <code>  Exit with the specified code if blocks were found. [default: 0]
   --message <warn>       Show the specified message if blocks were found.
   -x --extension <ext>   Search files only with the specified extensions.
+  -z --search-zip        Search inside of compressed files and archives.
   -M --mcp               Start MCP (Model Context Protocol) server on stdio.
+  --no-index             Do not use the index built by "blocksearch index".
+  --no-ignore            Do not respect .gitignore, .ignore and .blocksearchignore files.
+  --git-tracked          Search only files tracked by git.
+  --rev <ref>            Search files of the git revision without checking it out.
+  --diff <base>          Keep only blocks with lines changed since the git revision.
+  --staged               Keep only blocks with staged lines, searching the git index.
   --workdir <dir>        Working directory for MCP server (default: current directory).
   -v                     Be verbose.
   --version              Show version.
@@ -45,22 +72,59 @@ Options:
 `
 )
 
+// commandNames are taken for commands instead of the query unless they
+// follow --.
+var commandNames = []string{"index", "diff"}
+
 type Arguments struct {
-	ValueHigherThan int      `docopt:"-i"`
-	ValuePipeStream string   `docopt:"--stream"`
-	ValueFilters    []string `docopt:"--filter"`
-	ValueExtensions []string `docopt:"--extension"`
-	ValueExitCode   int      `docopt:"--exit-code"`
-	ValueAwkIfs     []string `docopt:"--awk"`
-	ValueMessage    string   `docopt:"--message"`
-	ValueWorkdir    string   `docopt:"--workdir"`
+	ValueHigherThan  int      `docopt:"-i"`
+	ValueStrategy    string   `docopt:"--strategy"`
+	ValueTabWidth    int      `docopt:"--tab-width"`
+	ValueMaxLine     int      `docopt:"--max-line-length"`
+	ValueMaxSize     string   `docopt:"--max-filesize"`
+	ValueMaxLines    int      `docopt:"--max-block-lines"`
+	ValueMaxBlocks   int      `docopt:"--max-blocks"`
+	ValueEncoding    string   `docopt:"--encoding"`
+	ValueRevision    string   `docopt:"--rev"`
+	ValueDiff        string   `docopt:"--diff"`
+	ValueConfig      string   `docopt:"--config"`
+	ValueUp          string   `docopt:"--up"`
+	ValueOverlap     string   `docopt:"--overlap"`
+	ValueContains    []string `docopt:"--contains"`
+	ValueNotContains []string `docopt:"--not-contains"`
+	ValuePipeStream  string   `docopt:"--stream"`
+	ValueFilters     []string `docopt:"--filter"`
+	ValueExtensions  []string `docopt:"--extension"`
+	ValueExitCode    int      `docopt:"--exit-code"`
+	ValueJobs        int      `docopt:"--jobs"`
+	ValueAwkIfs      []string `docopt:"--awk"`
+	ValueAwkMode     string   `docopt:"--awk-mode"`
+	ValueAwkPrint    string   `docopt:"--awk-print"`
+	ValueMessage     string   `docopt:"--message"`
+	ValueWorkdir     string   `docopt:"--workdir"`
 
 	FlagShowFilenamePerLine bool `docopt:"--file"`
 	FlagNoShowLineNumber    bool `docopt:"--no-line"`
 	FlagNoColors            bool `docopt:"--no-colors"`
 	FlagJSON                bool `docopt:"--json"`
 	FlagVerbose             bool `docopt:"-v"`
+	FlagDocs                bool `docopt:"--docs"`
+	FlagMultiline           bool `docopt:"--multiline"`
 	FlagMCP                 bool `docopt:"--mcp"`
+	FlagNoIndex             bool `docopt:"--no-index"`
+	FlagSearchZip           bool `docopt:"--search-zip"`
+	FlagNoIgnore            bool `docopt:"--no-ignore"`
+	FlagGitTracked          bool `docopt:"--git-tracked"`
+	FlagStaged              bool `docopt:"--staged"`
+	FlagEndOfOptions        bool `docopt:"--"`
+
+	CommandIndex  bool `docopt:"index"`
+	CommandBuild  bool `docopt:"build"`
+	CommandUpdate bool `docopt:"update"`
+	CommandDiff   bool `docopt:"diff"`
+
+	ValueFromRevision string `docopt:"<rev1>"`
+	ValueToRevision   string `docopt:"<rev2>"`
 
 	ValueQuery string   `docopt:"<query>"`
 	ValueFiles []string `docopt:"<file>"`
@@ -78,9 +142,14 @@ func main() {
 		panic(err)
 	}
 
+	config, err := loadConfig(args.ValueConfig)
+	if err != nil {
+		log.Fatalf(err, "invalid config")
+	}
+
 	// Handle MCP subcommand
 	if args.FlagMCP {
-		mcpServer, err := NewMCPServer(args.ValueWorkdir)
+		mcpServer, err := NewMCPServer(args.ValueWorkdir, config)
 		if err != nil {
 			log.Fatalf(err, "failed to create MCP server")
 		}
@@ -99,98 +168,282 @@ func main() {
 		log.SetLevel(lorg.LevelDebug)
 	}
 
-	query, err := regexp.Compile(args.ValueQuery)
+	maxFileSize, err := parseSize(args.ValueMaxSize)
+	if err != nil {
+		log.Fatalf(err, "invalid --max-filesize")
+	}
+
+	fallback := config.Encoding
+	if args.ValueEncoding != "" {
+		fallback, err = parseEncoding(args.ValueEncoding)
+		if err != nil {
+			log.Fatalf(err, "invalid --encoding")
+		}
+	}
+
+	if args.CommandIndex {
+		err := updateIndex(extensions, args.CommandUpdate, fallback, maxFileSize)
+		if err != nil {
+			log.Fatalf(err, "unable to index files")
+		}
+
+		return
+	}
+
+	err = checkCommand(args)
+	if err != nil {
+		log.Fatalf(err, "invalid command")
+	}
+
+	query, err := compileQuery(args.ValueQuery, args.FlagMultiline)
 	if err != nil {
 		log.Fatalf(err, "invalid regexp")
 	}
 
+	blockOptions := BlockOptions{
+		HigherThan:      args.ValueHigherThan,
+		Strategy:        args.ValueStrategy,
+		TabWidth:        args.ValueTabWidth,
+		DefaultTabWidth: config.TabWidth,
+		IncludeDocs:     args.FlagDocs,
+		Multiline:       args.FlagMultiline,
+		MaxLineLength:   args.ValueMaxLine,
+		MaxBlockLines:   args.ValueMaxLines,
+		MaxFileSize:     maxFileSize,
+		Encoding:        fallback,
+		Literal:         getRequiredLiteral(query),
+	}
+
+	err = checkBlockStrategy(blockOptions.Strategy)
+	if err != nil {
+		log.Fatalf(err, "invalid block strategy")
+	}
+
+	blockOptions.Up, err = parseUp(args.ValueUp)
+	if err != nil {
+		log.Fatalf(err, "invalid --up")
+	}
+
+	blockOptions.Overlap, err = parseOverlapPolicy(args.ValueOverlap)
+	if err != nil {
+		log.Fatalf(err, "invalid --overlap")
+	}
+
+	blockOptions.Contains, err = compileContentPatterns(args.ValueContains)
+	if err != nil {
+		log.Fatalf(err, "invalid --contains")
+	}
+
+	blockOptions.NotContains, err = compileContentPatterns(args.ValueNotContains)
+	if err != nil {
+		log.Fatalf(err, "invalid --not-contains")
+	}
+
+	if args.CommandDiff {
+		found := diffRevisions(args, extensions, query, blockOptions)
+		if found {
+			if args.ValueMessage != "" {
+				fmt.Println(args.ValueMessage)
+			}
+
+			os.Exit(args.ValueExitCode)
+		}
+
+		return
+	}
+
+	// files of git are searched in the current directory by default
+	useGit := args.FlagGitTracked || args.ValueRevision != "" ||
+		args.ValueDiff != "" || args.FlagStaged
+
 	files := args.ValueFiles
 	if len(args.ValueFiles) == 0 {
-		if isatty.IsTerminal(os.Stdin.Fd()) {
+		if useGit || isatty.IsTerminal(os.Stdin.Fd()) {
 			files = []string{"."}
 		} else {
 			files = []string{"/dev/stdin"}
 		}
 	}
 
+	awkMode, err := parseAwkMode(args.ValueAwkMode)
+	if err != nil {
+		log.Fatalf(err, "invalid --awk-mode")
+	}
+
 	var filters []*AwkwardMatcher
 	for _, filter := range args.ValueAwkIfs {
-		filters = append(
-			filters,
-			NewAwkwardMatcher(filter),
-		)
+		matcher, err := NewAwkwardMatcher(filter)
+		if err != nil {
+			log.Fatalf(err, "invalid --awk")
+		}
+
+		filters = append(filters, matcher)
+	}
+
+	var printer *AwkwardPrinter
+	if args.ValueAwkPrint != "" {
+		printer, err = NewAwkwardPrinter(args.ValueAwkPrint)
+		if err != nil {
+			log.Fatalf(err, "invalid --awk-print")
+		}
 	}
 
 	// Create file walker with current directory as base
-	walker := NewFileWalker(".", extensions)
+	walker := NewFileWalker(extensions)
+	walker.SearchZip = args.FlagSearchZip
+	walker.NoIgnore = args.FlagNoIgnore
+	walker.GitTracked = args.FlagGitTracked
+	walker.Revision = args.ValueRevision
+
+	if walker.Revision != "" {
+		err := checkRevision(walker.Revision)
+		if err != nil {
+			log.Fatalf(err, "invalid --rev")
+		}
+	}
+
+	if args.ValueDiff != "" || args.FlagStaged {
+		if walker.Revision != "" {
+			log.Fatal("--diff and --staged can't be used with --rev")
+		}
+
+		if args.ValueDiff != "" {
+			err := checkRevision(args.ValueDiff)
+			if err != nil {
+				log.Fatalf(err, "invalid --diff")
+			}
+		}
+
+		walker.Staged = args.FlagStaged
+		walker.Diff, err = loadDiff(args.ValueDiff, args.FlagStaged)
+		if err != nil {
+			log.Fatalf(err, "unable to get changed lines")
+		}
+	}
+
+	if !args.FlagNoIndex {
+		index, err := loadIndex(indexPath)
+		if err != nil {
+			log.Errorf(err, "index is not used")
+		} else if index != nil {
+			walker.UseIndex(index, query, blockOptions.Encoding)
+		}
+	}
+
+	render := func(path string, blocks Blocks) (string, error) {
+		switch {
+		case args.ValuePipeStream != "":
+			// streaming runs commands, it's done by the collector to keep
+			// the order
+			return "", nil
+		case args.FlagJSON:
+			buffer, err := blocks.EncodeJSON(path)
+			if err != nil {
+				return "", karma.Format(err, "json encode blocks")
+			}
+
+			return string(buffer), nil
+		default:
+			return strings.Join(
+				blocks.Format(
+					args.FlagShowFilenamePerLine,
+					path,
+					!args.FlagNoShowLineNumber,
+					!args.FlagNoColors,
+				),
+				"\n\n",
+			), nil
+		}
+	}
+
+	search := func(file File) searchResult {
+		path := file.Path
+
+		log.Debug("process: " + path)
+
+		result := searchResult{Path: path}
+
+		blocks, err := findFileBlocks(file, query, blockOptions)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		if walker.Diff != nil {
+			blocks = walker.Diff.Filter(path, blocks)
+		}
+
+		blocks, err = filterBlocks(path, blocks, filters, awkMode)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		blocks, err = printBlocks(path, blocks, printer)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		result.Blocks = blocks
+		if len(blocks) == 0 {
+			return result
+		}
+
+		result.Output, result.Err = render(path, blocks)
+
+		return result
+	}
 
 	found := 0
 	shouldAddLine := false
-	for _, file := range files {
-		log.Debug("stat: " + file)
+	collect := func(result searchResult) bool {
+		if result.Err != nil {
+			logSearchError(result)
+			return true
+		}
 
-		process := func(path string) error {
-			log.Debug("process: " + path)
+		if len(result.Blocks) == 0 {
+			return true
+		}
 
-			blocks, err := findBlocks(path, query, args.ValueHigherThan)
+		limit := args.ValueMaxBlocks
+		if limit > 0 && found+len(result.Blocks) > limit {
+			result.Blocks = result.Blocks[:limit-found]
+
+			var err error
+			result.Output, err = render(result.Path, result.Blocks)
 			if err != nil {
-				log.Errorf(err, "%s", path)
-				return nil
+				log.Errorf(err, "%s", result.Path)
+				return false
 			}
+		}
+
+		found += len(result.Blocks)
 
-			blocks, err = filterBlocks(blocks, filters)
+		switch {
+		case args.ValuePipeStream != "":
+			err := result.Blocks.Stream(args.ValuePipeStream, result.Path)
 			if err != nil {
-				log.Errorf(err, "%s", path)
-				return nil
+				log.Errorf(err, "stream failed")
 			}
-
-			if len(blocks) == 0 {
-				return nil
+		case args.FlagJSON:
+			os.Stdout.WriteString(result.Output)
+		default:
+			if shouldAddLine {
+				fmt.Println()
 			}
 
-			found += len(blocks)
-
-			switch {
-			case args.ValuePipeStream != "":
-				err := blocks.Stream(args.ValuePipeStream, path)
-				if err != nil {
-					log.Errorf(err, "stream failed")
-				}
-			case args.FlagJSON:
-				buffer, err := blocks.EncodeJSON(path)
-				if err != nil {
-					log.Errorf(err, "json encode blocks")
-				} else {
-					os.Stdout.Write(buffer)
-				}
-			default:
-				if shouldAddLine {
-					fmt.Println()
-				}
-
-				fmt.Println(
-					strings.Join(
-						blocks.Format(
-							args.FlagShowFilenamePerLine,
-							path,
-							!args.FlagNoShowLineNumber,
-							!args.FlagNoColors,
-						),
-						"\n\n",
-					),
-				)
+			fmt.Println(result.Output)
 
-				shouldAddLine = true
-			}
-			return nil
+			shouldAddLine = true
 		}
 
-		err := walker.Walk(file, process)
-		if err != nil {
-			log.Errorf(err, "%s", file)
-		}
+		return limit <= 0 || found < limit
 	}
 
+	searchFiles(walker, files, args.ValueJobs, search, collect)
+
 	if found != 0 {
 		if args.ValueMessage != "" {
 			fmt.Println(args.ValueMessage)
@@ -200,6 +453,108 @@ func main() {
 	}
 }
 
+// diffRevisions prints blocks changed between revisions given by arguments
+// of the diff command and reports whether there are any.
+func diffRevisions(
+	args Arguments,
+	extensions []string,
+	query *regexp.Regexp,
+	options BlockOptions,
+) bool {
+	for _, revision := range []string{args.ValueFromRevision, args.ValueToRevision} {
+		err := checkRevision(revision)
+		if err != nil {
+			log.Fatalf(err, "invalid revision")
+		}
+	}
+
+	files := args.ValueFiles
+	if len(files) == 0 {
+		files = []string{"."}
+	}
+
+	changes := compareRevisions(
+		NewFileWalker(extensions),
+		args.ValueFromRevision,
+		args.ValueToRevision,
+		files,
+		args.ValueJobs,
+		query,
+		options,
+	)
+
+	for i, change := range changes {
+		if args.FlagJSON {
+			buffer, err := change.EncodeJSON(
+				args.ValueFromRevision,
+				args.ValueToRevision,
+			)
+			if err != nil {
+				log.Fatalf(err, "json encode changes")
+			}
+
+			os.Stdout.Write(append(buffer, '\n'))
+
+			continue
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Println(change.Format(!args.FlagNoColors))
+	}
+
+	return len(changes) > 0
+}
+
+// checkCommand returns error if the query is the name of a command, so
+// arguments of the command didn't match, like of "index biuld". Names of
+// commands are searched only when they follow --.
+func checkCommand(args Arguments) error {
+	if args.FlagEndOfOptions || !isOneOf(args.ValueQuery, commandNames) {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"unexpected arguments of the %s command, put -- before the query to search for %q",
+		args.ValueQuery,
+		args.ValueQuery,
+	)
+}
+
+// updateIndex builds the index of the current directory, the existing index
+// is reused for unchanged files if update is true.
+func updateIndex(
+	extensions []string,
+	update bool,
+	fallback encoding.Encoding,
+	maxFileSize int64,
+) error {
+	var previous *Index
+	if update {
+		var err error
+		previous, err = loadIndex(indexPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	index, err := buildIndex(
+		NewFileWalker(extensions),
+		previous,
+		fallback,
+		maxFileSize,
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf(nil, "indexed files: %d", len(index.Files))
+
+	return index.Save(indexPath)
+}
+
 func expandExtensions(args []string) []string {
 	result := []string{}
 	for _, ext := range args {
@@ -221,26 +576,82 @@ func hasExtension(path string, extensions []string) bool {
 	return false
 }
 
-// FileWalker handles walking through files respecting gitignore patterns
+// FileWalker handles walking through files respecting ignore files like
+// .gitignore
 type FileWalker struct {
-	ignoreMatcher       gitignore.IgnoreMatcher
-	globalIgnoreMatcher gitignore.IgnoreMatcher
-	extensions          []string
+	extensions []string
+
+	// index and trigrams of the query narrow files to search
+	index    *Index
+	trigrams []uint32
+
+	// SearchZip expands compressed files and archives into virtual files.
+	SearchZip bool
+
+	// NoIgnore disables .gitignore, .ignore, .blocksearchignore and global
+	// excludes of git.
+	NoIgnore bool
+
+	// GitTracked walks only files in the git index instead of directories.
+	GitTracked bool
+
+	// Revision walks files of the git revision read from the repository
+	// instead of files on disk.
+	Revision string
+
+	// Diff skips files without changed lines, blocks are filtered by it
+	// after they are found.
+	Diff *Diff
+
+	// Staged walks files of the git index instead of files on disk, it's
+	// used with Diff of staged changes.
+	Staged bool
 }
 
-// NewFileWalker creates a new FileWalker with gitignore patterns loaded from the given base directory
-func NewFileWalker(baseDir string, extensions []string) *FileWalker {
-	fw := &FileWalker{
+// NewFileWalker creates a new FileWalker searching files with the given
+// extensions, all files if there are none.
+func NewFileWalker(extensions []string) *FileWalker {
+	return &FileWalker{
 		extensions: extensions,
 	}
+}
+
+// UseIndex makes the walker skip indexed files which can't match the query,
+// the index built with another encoding is not used.
+func (fw *FileWalker) UseIndex(
+	index *Index,
+	query *regexp.Regexp,
+	fallback encoding.Encoding,
+) {
+	if index.Encoding != getEncodingName(fallback) {
+		log.Debugf(
+			nil,
+			"index is not used: built with encoding %q",
+			index.Encoding,
+		)
+		return
+	}
+
+	fw.index = index
+	fw.trigrams = getQueryTrigrams(query)
+}
+
+// mayMatch checks the diff and the index if the file can match the query.
+func (fw *FileWalker) mayMatch(path string, info os.FileInfo) bool {
+	if fw.Diff != nil && !fw.Diff.Has(path) {
+		return false
+	}
 
-	gitignorePath := filepath.Join(baseDir, ".gitignore")
-	fw.ignoreMatcher, _ = gitignore.NewGitIgnore(gitignorePath)
+	if fw.index == nil || len(fw.trigrams) == 0 {
+		return true
+	}
 
-	globalGitignore := filepath.Join(os.Getenv("HOME"), ".gitignore_global")
-	fw.globalIgnoreMatcher, _ = gitignore.NewGitIgnore(globalGitignore)
+	// compressed files are indexed as binary ones
+	if fw.SearchZip && isCompressed(path) {
+		return true
+	}
 
-	return fw
+	return fw.index.mayContain(path, info, fw.trigrams)
 }
 
 // Walk iterates through files in the given path, calling processFile for each matching file
@@ -251,44 +662,187 @@ func (fw *FileWalker) Walk(path string, processFile func(path string) error) err
 	}
 
 	if stat.IsDir() {
-		return filepath.Walk(path, func(filePath string, info os.FileInfo, walkErr error) error {
-			if walkErr != nil {
-				return nil // Skip errors
-			}
+		if fw.GitTracked {
+			return fw.walkTracked(path, processFile)
+		}
+
+		return fw.walkDir(path, processFile)
+	}
+
+	if !fw.mayMatch(path, stat) {
+		return nil
+	}
+
+	return processFile(path)
+}
+
+// walkDir walks the directory stacking rules of ignore files found in
+// every directory on top of rules of its parent directory.
+func (fw *FileWalker) walkDir(root string, processFile func(path string) error) error {
+	// paths passed by filepath.Walk are cleaned, so they are the keys of
+	// the rules
+	root = filepath.Clean(root)
+
+	absolute, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	getAbsolute := func(path string) string {
+		relative, err := filepath.Rel(root, path)
+		if err != nil {
+			return path
+		}
 
-			if info.IsDir() {
-				if filepath.Base(filePath) == ".git" {
-					return filepath.SkipDir
-				}
+		return filepath.Join(absolute, relative)
+	}
 
-				if fw.ignoreMatcher != nil && fw.ignoreMatcher.Match(filePath, true) {
-					return filepath.SkipDir
-				}
+	// rules hold ignore rules for files of every walked directory
+	rules := map[string]*ignoreRules{}
+	if !fw.NoIgnore {
+		rules[root] = getIgnoreRules(absolute)
+	}
 
-				if fw.globalIgnoreMatcher != nil && fw.globalIgnoreMatcher.Match(filePath, true) {
-					return filepath.SkipDir
-				}
+	isIgnored := func(path string, dir bool) bool {
+		if fw.NoIgnore {
+			return false
+		}
 
+		return rules[filepath.Dir(path)].Match(getAbsolute(path), dir)
+	}
+
+	return filepath.Walk(root, func(filePath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return nil // Skip errors
+		}
+
+		if info.IsDir() {
+			if filePath == root {
 				return nil
 			}
 
-			if len(fw.extensions) != 0 && !hasExtension(filePath, fw.extensions) {
-				return nil
+			name := filepath.Base(filePath)
+			if name == ".git" || name == indexDir {
+				return filepath.SkipDir
 			}
 
-			if fw.ignoreMatcher != nil && fw.ignoreMatcher.Match(filePath, false) {
-				return nil
+			if isIgnored(filePath, true) {
+				return filepath.SkipDir
 			}
 
-			if fw.globalIgnoreMatcher != nil && fw.globalIgnoreMatcher.Match(filePath, false) {
-				return nil
+			if !fw.NoIgnore {
+				rules[filePath] = rules[filepath.Dir(filePath)].push(
+					getAbsolute(filePath),
+					ignoreFileNames...,
+				)
 			}
 
-			return processFile(filePath)
-		})
+			return nil
+		}
+
+		if !fw.matchesExtensions(filePath) {
+			return nil
+		}
+
+		if isIgnored(filePath, false) {
+			return nil
+		}
+
+		if !fw.mayMatch(filePath, info) {
+			return nil
+		}
+
+		return processFile(filePath)
+	})
+}
+
+// walkTracked walks files of the directory which are in the git index,
+// ignore files are not used since git already applied them.
+func (fw *FileWalker) walkTracked(root string, processFile func(path string) error) error {
+	files, err := listTrackedFiles(root)
+	if err != nil {
+		return err
 	}
 
-	return processFile(path)
+	for _, path := range files {
+		if !fw.matchesExtensions(path) {
+			continue
+		}
+
+		// deleted files and submodules are in the index as well
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		if !fw.mayMatch(path, info) {
+			continue
+		}
+
+		err = processFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchesExtensions reports whether the file has one of the extensions,
+// extensions of archive members are checked while reading the archive.
+func (fw *FileWalker) matchesExtensions(path string) bool {
+	if len(fw.extensions) == 0 || hasExtension(path, fw.extensions) {
+		return true
+	}
+
+	return fw.SearchZip && isCompressed(path)
+}
+
+// WalkFiles is like Walk, but compressed files and archives are expanded
+// into virtual files if SearchZip is set, and files of Revision or of the
+// git index if Staged is set are walked instead of files on disk.
+func (fw *FileWalker) WalkFiles(path string, processFile func(file File) error) error {
+	if fw.Revision != "" {
+		objects, err := listRevisionFiles(fw.Revision, path)
+		if err != nil {
+			return err
+		}
+
+		return walkObjects(objects, fw.extensions, processFile)
+	}
+
+	if fw.Staged {
+		objects, err := listStagedFiles(path)
+		if err != nil {
+			return err
+		}
+
+		changed := []gitObject{}
+		for _, object := range objects {
+			if fw.Diff == nil || fw.Diff.Has(object.path) {
+				changed = append(changed, object)
+			}
+		}
+
+		return walkObjects(changed, fw.extensions, processFile)
+	}
+
+	return fw.Walk(path, func(path string) error {
+		if !fw.SearchZip || !isCompressed(path) {
+			return processFile(File{Path: path})
+		}
+
+		err := walkCompressed(path, fw.extensions, processFile)
+		if err == errSearchStopped {
+			return err
+		}
+
+		if err != nil {
+			log.Errorf(err, "%s", path)
+		}
+
+		return nil
+	})
 }
 
 // ListFiles returns a list of all files matching the walker's criteria
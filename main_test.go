@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docopt/docopt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseTestArguments(t *testing.T, argv ...string) Arguments {
+	parser := &docopt.Parser{HelpHandler: docopt.NoHelpHandler}
+
+	opts, err := parser.ParseArgs(usage, argv, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var args Arguments
+	err = opts.Bind(&args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return args
+}
+
+func TestUsage_Commands(t *testing.T) {
+	test := assert.New(t)
+
+	args := parseTestArguments(t, "-j", "diff", "v1.4.0", "HEAD", "^func ", "-x", "go", "pkg")
+	test.True(args.CommandDiff)
+	test.True(args.FlagJSON)
+	test.Equal("v1.4.0", args.ValueFromRevision)
+	test.Equal("HEAD", args.ValueToRevision)
+	test.Equal("^func ", args.ValueQuery)
+	test.Equal([]string{"go"}, args.ValueExtensions)
+	test.Equal([]string{"pkg"}, args.ValueFiles)
+
+	args = parseTestArguments(t, "index", "build")
+	test.True(args.CommandIndex)
+	test.True(args.CommandBuild)
+
+	args = parseTestArguments(t, "index", "build", "-v")
+	test.True(args.CommandIndex)
+	test.True(args.FlagVerbose)
+
+	args = parseTestArguments(t, "index", "update", "--encoding", "latin1", "--max-filesize", "1M", "-x", "go")
+	test.True(args.CommandUpdate)
+	test.Equal("latin1", args.ValueEncoding)
+	test.Equal("1M", args.ValueMaxSize)
+	test.Equal([]string{"go"}, args.ValueExtensions)
+
+	// names of commands are searched when they follow --
+	args = parseTestArguments(t, "-x", "go", "--", "diff", "src", "lib", ".")
+	test.False(args.CommandDiff)
+	test.Equal("diff", args.ValueQuery)
+	test.Equal([]string{"src", "lib", "."}, args.ValueFiles)
+	test.Equal([]string{"go"}, args.ValueExtensions)
+
+	args = parseTestArguments(t, "--", "index", "build")
+	test.False(args.CommandIndex)
+	test.Equal("index", args.ValueQuery)
+	test.Equal([]string{"build"}, args.ValueFiles)
+
+	test.NoError(checkCommand(args))
+
+	// too few arguments for the diff command
+	args = parseTestArguments(t, "diff", "src")
+	test.False(args.CommandDiff)
+	test.Equal("diff", args.ValueQuery)
+	test.Error(checkCommand(args))
+
+	// unknown command of the index
+	args = parseTestArguments(t, "index", "biuld")
+	test.False(args.CommandIndex)
+	test.Error(checkCommand(args))
+
+	args = parseTestArguments(t, "foo", "src")
+	test.NoError(checkCommand(args))
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"strings"
+)
+
+// tagLanguages lists extensions of markup files where blocks are delimited by
+// opening and closing tags.
+var tagLanguages = map[string]bool{
+	"html":  true,
+	"htm":   true,
+	"xhtml": true,
+	"vue":   true,
+	"xml":   true,
+	"svg":   true,
+	"xsd":   true,
+	"xsl":   true,
+	"xslt":  true,
+	"plist": true,
+}
+
+// htmlLanguages lists tag languages which have void elements and raw text
+// elements like <br> and <script>.
+var htmlLanguages = map[string]bool{
+	"html":  true,
+	"htm":   true,
+	"xhtml": true,
+	"vue":   true,
+}
+
+var htmlVoidElements = []string{
+	"area", "base", "br", "col", "embed", "hr", "img", "input", "link",
+	"meta", "param", "source", "track", "wbr",
+}
+
+var htmlRawTextElements = []string{"script", "style"}
+
+// TagStrategy terminates the block when all tags opened in it are closed.
+type TagStrategy struct {
+	HTML bool
+
+	tags nestingLines
+}
+
+func (strategy *TagStrategy) Name() string {
+	return "tag"
+}
+
+func (strategy *TagStrategy) Prepare(lines []string) {
+	strategy.tags = scanTags(lines, strategy.HTML)
+}
+
+func (strategy *TagStrategy) GetBlockEnd(lines []string, start int) int {
+	return strategy.tags.getBlockEnd(lines, start, nil)
+}
+
+func (strategy *TagStrategy) GetLevel(lines []string, index int) int {
+	return strategy.tags.getLevel(index)
+}
+
+// scanTags tracks nesting of tags through the whole file. An unfinished
+// opening tag spanning several lines counts as opened until its '>'.
+func scanTags(lines []string, html bool) nestingLines {
+	var (
+		result = make(nestingLines, len(lines))
+		depth  = 0
+
+		// until holds the terminator of comment, CDATA, declaration or raw
+		// text the scanner is currently in
+		until = ""
+		// tag holds the name of the opening tag the scanner is in
+		tag   = ""
+		quote = byte(0)
+	)
+
+	for index, line := range lines {
+		stat := nestingLine{start: depth, min: depth, max: depth}
+
+		for i := 0; i < len(line); {
+			switch {
+			case until != "":
+				closing := strings.Index(strings.ToLower(line[i:]), until)
+				if closing < 0 {
+					i = len(line)
+					continue
+				}
+
+				i += closing
+				if !strings.HasPrefix(until, "</") {
+					i += len(until)
+				}
+
+				until = ""
+
+			case quote != 0:
+				closing := strings.IndexByte(line[i:], quote)
+				if closing < 0 {
+					i = len(line)
+					continue
+				}
+
+				i += closing + 1
+				quote = 0
+
+			case tag != "":
+				switch line[i] {
+				case '"', '\'':
+					quote = line[i]
+				case '>':
+					selfClosing := i > 0 && line[i-1] == '/'
+					if selfClosing || html && isOneOf(tag, htmlVoidElements) {
+						depth--
+						stat.update(depth)
+					} else if html && isOneOf(tag, htmlRawTextElements) {
+						until = "</" + tag
+					}
+
+					tag = ""
+				}
+
+				i++
+
+			case strings.HasPrefix(line[i:], "<!--"):
+				until = "-->"
+				i += len("<!--")
+
+			case strings.HasPrefix(line[i:], "<![CDATA["):
+				until = "]]>"
+				i += len("<![CDATA[")
+
+			case strings.HasPrefix(line[i:], "<!"),
+				strings.HasPrefix(line[i:], "<?"):
+				until = ">"
+				i += 2
+
+			case strings.HasPrefix(line[i:], "</"):
+				depth--
+				stat.update(depth)
+
+				closing := strings.IndexByte(line[i:], '>')
+				if closing < 0 {
+					i = len(line)
+				} else {
+					i += closing + 1
+				}
+
+			case line[i] == '<' && i+1 < len(line) && isWordStart(line[i+1]):
+				end := i + 1
+				for end < len(line) && isTagNameChar(line[end]) {
+					end++
+				}
+
+				tag = strings.ToLower(line[i+1 : end])
+				depth++
+				stat.update(depth)
+				i = end
+
+			default:
+				i++
+			}
+		}
+
+		stat.end = depth
+		result[index] = stat
+	}
+
+	return result
+}
+
+func isTagNameChar(char byte) bool {
+	return isWordChar(char) || char == '-' || char == ':' || char == '.'
+}
+
+// headingLanguages maps extensions of documents which are split into
+// sections by headings to the character headings are made of.
+var headingLanguages = map[string]byte{
+	"md":       '#',
+	"markdown": '#',
+	"mdx":      '#',
+	"org":      '*',
+}
+
+// HeadingStrategy extends a matching heading up to the next heading of the
+// same or a higher level; any other matching line yields its paragraph.
+type HeadingStrategy struct {
+	Marker byte
+
+	levels []int
+
+	// sections are levels of the nearest heading at or above every line
+	sections []int
+}
+
+func (strategy *HeadingStrategy) Name() string {
+	return "heading"
+}
+
+func (strategy *HeadingStrategy) Prepare(lines []string) {
+	strategy.levels = make([]int, len(lines))
+	strategy.sections = make([]int, len(lines))
+
+	var (
+		fence   = ""
+		section = 0
+	)
+
+	for index, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		} else if strategy.Marker == '#' &&
+			(strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")) {
+			fence = trimmed[:3]
+		} else {
+			strategy.levels[index] = getHeadingLevel(line, strategy.Marker)
+			if strategy.levels[index] != 0 {
+				section = strategy.levels[index]
+			}
+		}
+
+		strategy.sections[index] = section
+	}
+}
+
+func (strategy *HeadingStrategy) GetBlockEnd(lines []string, start int) int {
+	level := strategy.levels[start]
+
+	end := start
+	for next := start + 1; next < len(lines); next++ {
+		if level == 0 && strings.TrimSpace(lines[next]) == "" {
+			break
+		}
+
+		if strategy.levels[next] != 0 &&
+			(level == 0 || strategy.levels[next] <= level) {
+			break
+		}
+
+		end = next
+	}
+
+	for end > start && strings.TrimSpace(lines[end]) == "" {
+		end--
+	}
+
+	return end
+}
+
+// GetLevel returns level of the section for lines of the section and one
+// less for its heading, so headings of higher levels enclose it.
+func (strategy *HeadingStrategy) GetLevel(lines []string, index int) int {
+	if strategy.levels[index] != 0 {
+		return strategy.levels[index] - 1
+	}
+
+	return strategy.sections[index]
+}
+
+// getHeadingLevel returns level of the heading made of the given marker
+// characters or zero if the line is not a heading.
+func getHeadingLevel(line string, marker byte) int {
+	if marker == '#' {
+		trimmed := strings.TrimLeft(line, " ")
+		if len(line)-len(trimmed) > 3 {
+			return 0
+		}
+
+		line = trimmed
+	}
+
+	level := 0
+	for level < len(line) && line[level] == marker {
+		level++
+	}
+
+	if level == 0 || marker == '#' && level > 6 {
+		return 0
+	}
+
+	if level < len(line) && line[level] != ' ' && line[level] != '\t' {
+		return 0
+	}
+
+	return level
+}
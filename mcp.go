@@ -4,18 +4,31 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// Default limits of the search tool, so a broad query doesn't flood the
+// context of the model.
+const (
+	mcpMaxFileSize   = "1M"
+	mcpMaxBlockLines = 200
+	mcpMaxBlocks     = 100
+)
+
 // MCPServer wraps the blocksearch functionality as an MCP server
-type MCPServer struct{}
+type MCPServer struct {
+	config Config
+
+	// index is kept in memory between searches
+	index indexCache
+}
 
 // NewMCPServer creates a new MCP server instance for the given working directory
-func NewMCPServer(workdir string) (*MCPServer, error) {
+func NewMCPServer(workdir string, config Config) (*MCPServer, error) {
 	if workdir == "" {
 		workdir = "."
 	}
@@ -25,7 +38,12 @@ func NewMCPServer(workdir string) (*MCPServer, error) {
 		return nil, fmt.Errorf("change to workdir: %w", err)
 	}
 
-	return &MCPServer{}, nil
+	m := &MCPServer{config: config}
+
+	// load the index before the first search
+	m.index.Get()
+
+	return m, nil
 }
 
 // Run starts the MCP server on stdio
@@ -72,16 +90,136 @@ Example queries:
 				"Adjust how much nested content to capture. 0 (default) captures the block at match level. Positive values include more nested content, negative values capture less. Use -1 to get just the matching line's block without deeper nesting.",
 			),
 		),
+		mcp.WithString(
+			"strategy",
+			mcp.Enum(getBlockStrategyNames()...),
+			mcp.Description(
+				"How the end of a block is found. 'auto' (default) picks by file extension: 'brace' for C-family languages, 'keyword' for Ruby/Lua/SQL/shell (do/end, if/fi), 'tag' for HTML/XML, 'heading' for Markdown/Org, 'indent' for everything else.",
+			),
+		),
+		mcp.WithNumber(
+			"tab_width",
+			mcp.Description(
+				"Number of columns a tab advances to when measuring indentation of files mixing tabs and spaces. Default: from .editorconfig, otherwise 8.",
+			),
+		),
+		mcp.WithBoolean(
+			"include_docs",
+			mcp.Description(
+				"Include comments, decorators and annotations placed right above the matching line (Go doc comments, Python @decorators, Java @Annotations, Rust #[attributes]). Default: false",
+			),
+		),
+		mcp.WithString(
+			"up",
+			mcp.Description(
+				"Return the block enclosing the match instead of the match itself: number of levels to climb (indentation, or nesting of brackets, keywords, tags or headings by the block strategy) or 'top' for the top level block. Example: query 'panic\\(' with up 'top' returns whole functions that panic. Default: 0",
+			),
+		),
+		mcp.WithString(
+			"overlap",
+			mcp.Enum("skip", "merge", "nested"),
+			mcp.Description(
+				"What to do with matches inside of already found blocks: 'skip' (default) records them as matches of the found block, 'merge' also merges overlapping and adjacent blocks into one, 'nested' returns a separate block for every match.",
+			),
+		),
+		mcp.WithBoolean(
+			"multiline",
+			mcp.Description(
+				"Match the query against the whole file instead of line by line, so patterns can span lines, e.g. 'if err != nil {\\s*return nil'. The block starts at the first line of the match and covers the entire match. Default: false",
+			),
+		),
+		mcp.WithBoolean(
+			"no_ignore",
+			mcp.Description(
+				"Search files ignored by .gitignore, .ignore, .blocksearchignore, .git/info/exclude and core.excludesFile as well. Default: false",
+			),
+		),
+		mcp.WithBoolean(
+			"git_tracked",
+			mcp.Description(
+				"Search only files tracked by git. Default: false",
+			),
+		),
+		mcp.WithString(
+			"rev",
+			mcp.Description(
+				"Search files of the git revision (commit, branch or tag) without checking it out, e.g. 'release-1.4' or 'HEAD~3'. Files are reported by paths like 'release-1.4:path/to/file.go'. Default: files on disk",
+			),
+		),
+		mcp.WithString(
+			"diff",
+			mcp.Description(
+				"Return only blocks containing lines changed since the git revision, e.g. 'origin/main'. Can't be used with 'rev'. Default: all blocks",
+			),
+		),
+		mcp.WithBoolean(
+			"staged",
+			mcp.Description(
+				"Return only blocks containing staged lines, files of the git index are searched. Can't be used with 'rev'. Default: false",
+			),
+		),
+		mcp.WithBoolean(
+			"search_zip",
+			mcp.Description(
+				"Search inside of .gz and .zst files and .tar, .tar.gz, .tgz, .tar.zst and .zip archives. Files inside of them are reported by paths like 'bundle.tar.gz:path/inside/file.yaml'. Default: false",
+			),
+		),
+		mcp.WithString(
+			"max_filesize",
+			mcp.Description(
+				"Skip files larger than this size, with optional K, M or G suffix, skipped files are listed after the found blocks. Default: '"+mcpMaxFileSize+"'",
+			),
+		),
+		mcp.WithNumber(
+			"max_block_lines",
+			mcp.Description(
+				"Truncate blocks longer than this number of lines, truncated blocks end with '... truncated, N more lines'. 0 disables the limit. Default: "+strconv.Itoa(mcpMaxBlockLines),
+			),
+		),
+		mcp.WithNumber(
+			"max_blocks",
+			mcp.Description(
+				"Stop after this number of blocks. 0 disables the limit. Default: "+strconv.Itoa(mcpMaxBlocks),
+			),
+		),
 		mcp.WithString("path",
 			mcp.Description("File or directory to search. Examples: '.' (entire repo), 'src', 'cmd/main.go'. Default: '.'"),
 		),
 		mcp.WithString("extensions",
 			mcp.Description("Limit search to specific file types. Comma-separated, without dots. Examples: 'go', 'py,js,ts', 'java'. Default: all text files"),
 		),
-		mcp.WithString(
+		mcp.WithArray(
+			"contains",
+			mcp.WithStringItems(),
+			mcp.Description(
+				"Keep only blocks whose text matches ALL of these regular expressions. The text is all lines of the block including leading comments attached by include_docs, but not the enclosing blocks. Example: query 'func .*Handler' with contains ['ctx\\.Done\\(\\)'] finds handlers watching the context.",
+			),
+		),
+		mcp.WithArray(
+			"not_contains",
+			mcp.WithStringItems(),
+			mcp.Description(
+				"Drop blocks whose text matches ANY of these regular expressions. Example: ['defer cancel'].",
+			),
+		),
+		mcp.WithArray(
 			"awk_filter",
+			mcp.WithStringItems(),
 			mcp.Description(
-				"Secondary filter using AWK expressions to refine results. The entire block is available as input. Examples: '/TODO/' (blocks containing TODO), '/return.*error/' (blocks with error returns), 'length > 500' (large blocks)",
+				"Secondary filters using AWK conditions to refine results, combined according to awk_mode. The entire block is available as input. Examples: '/TODO/' (blocks containing TODO), '/return.*error/' (blocks with error returns), 'length > 500' (large blocks). Variables FILENAME, LINE_START, LINE_END, NLINES, DEPTH, MATCH and LANG describe the block, e.g. 'NLINES > 80 && FILENAME ~ /_test\\.go$/'",
+			),
+		),
+		mcp.WithString(
+			"awk_mode",
+			mcp.Enum("any", "all", "none"),
+			mcp.Description(
+				"How awk_filter conditions are combined: 'any' (default) keeps blocks matching at least one condition, 'all' keeps blocks matching every condition, 'none' keeps blocks matching no condition.",
+			),
+		),
+		mcp.WithString(
+			"awk_print",
+			mcp.Description(
+				"AWK program run over lines of every block, its output replaces the block text, blocks it prints nothing for are dropped. The same variables as in awk_filter are available. Example: 'NR == 1' returns only the first line of every block, like function signatures.",
 			),
 		),
 	)
@@ -90,7 +228,7 @@ Example queries:
 
 	// Register the list_files tool
 	listFilesTool := mcp.NewTool("list_files",
-		mcp.WithDescription(`List files in the repository, respecting .gitignore patterns. Useful for understanding project structure before searching.
+		mcp.WithDescription(`List files in the repository, respecting .gitignore, .ignore and .blocksearchignore files. Useful for understanding project structure before searching.
 
 Use this to:
 - Discover what file types exist in a project
@@ -119,7 +257,9 @@ func (m *MCPServer) handleSearchBlocks(ctx context.Context, request mcp.CallTool
 		return mcp.NewToolResultError("query parameter is required"), nil
 	}
 
-	query, err := regexp.Compile(queryStr)
+	multiline, _ := args["multiline"].(bool)
+
+	query, err := compileQuery(queryStr, multiline)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("invalid regex pattern: %v", err)), nil
 	}
@@ -130,6 +270,87 @@ func (m *MCPServer) handleSearchBlocks(ctx context.Context, request mcp.CallTool
 		higherThan = int(offset)
 	}
 
+	blockOptions := BlockOptions{
+		HigherThan:      higherThan,
+		DefaultTabWidth: m.config.TabWidth,
+		Encoding:        m.config.Encoding,
+		Multiline:       multiline,
+		MaxBlockLines:   mcpMaxBlockLines,
+		Literal:         getRequiredLiteral(query),
+	}
+
+	maxFileSize, ok := args["max_filesize"].(string)
+	if !ok {
+		maxFileSize = mcpMaxFileSize
+	}
+
+	blockOptions.MaxFileSize, err = parseSize(maxFileSize)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	if maxBlockLines, ok := args["max_block_lines"].(float64); ok {
+		blockOptions.MaxBlockLines = int(maxBlockLines)
+	}
+
+	maxBlocks := mcpMaxBlocks
+	if value, ok := args["max_blocks"].(float64); ok {
+		maxBlocks = int(value)
+	}
+
+	if strategy, ok := args["strategy"].(string); ok {
+		blockOptions.Strategy = strategy
+	}
+
+	if tabWidth, ok := args["tab_width"].(float64); ok {
+		blockOptions.TabWidth = int(tabWidth)
+	}
+
+	if includeDocs, ok := args["include_docs"].(bool); ok {
+		blockOptions.IncludeDocs = includeDocs
+	}
+
+	if overlap, ok := args["overlap"].(string); ok {
+		blockOptions.Overlap, err = parseOverlapPolicy(overlap)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+	}
+
+	blockOptions.Contains, err = compileContentPatterns(
+		getStringArray(args["contains"]),
+	)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	blockOptions.NotContains, err = compileContentPatterns(
+		getStringArray(args["not_contains"]),
+	)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	// numbers are validated like strings, so negative and fractional
+	// numbers of levels are rejected as by the CLI
+	switch up := args["up"].(type) {
+	case float64:
+		blockOptions.Up, err = parseUp(strconv.FormatFloat(up, 'f', -1, 64))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+	case string:
+		blockOptions.Up, err = parseUp(up)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+	}
+
+	err = checkBlockStrategy(blockOptions.Strategy)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
 	searchPath := "."
 	if p, ok := args["path"].(string); ok && p != "" {
 		searchPath = p
@@ -140,43 +361,163 @@ func (m *MCPServer) handleSearchBlocks(ctx context.Context, request mcp.CallTool
 		extensions = expandExtensions([]string{ext})
 	}
 
+	awkMode, _ := args["awk_mode"].(string)
+	mode, err := parseAwkMode(awkMode)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
 	var filters []*AwkwardMatcher
-	if awkFilter, ok := args["awk_filter"].(string); ok && awkFilter != "" {
-		filters = append(filters, NewAwkwardMatcher(awkFilter))
+	for _, awkFilter := range getStringArray(args["awk_filter"]) {
+		matcher, err := NewAwkwardMatcher(awkFilter)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		filters = append(filters, matcher)
+	}
+
+	var printer *AwkwardPrinter
+	if awkPrint, ok := args["awk_print"].(string); ok && awkPrint != "" {
+		printer, err = NewAwkwardPrinter(awkPrint)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 	}
 
 	// Create file walker
-	walker := NewFileWalker(".", extensions)
+	walker := NewFileWalker(extensions)
+	walker.SearchZip, _ = args["search_zip"].(bool)
+	walker.NoIgnore, _ = args["no_ignore"].(bool)
+	walker.GitTracked, _ = args["git_tracked"].(bool)
+	walker.Revision, _ = args["rev"].(string)
+
+	if walker.Revision != "" {
+		err := checkRevision(walker.Revision)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+	}
+
+	base, _ := args["diff"].(string)
+	walker.Staged, _ = args["staged"].(bool)
+
+	if base != "" || walker.Staged {
+		if walker.Revision != "" {
+			return mcp.NewToolResultError("diff and staged can't be used with rev"), nil
+		}
+
+		if base != "" {
+			err := checkRevision(base)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+		}
+
+		walker.Diff, err = loadDiff(base, walker.Staged)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+	}
+
+	if index := m.index.Get(); index != nil {
+		walker.UseIndex(index, query, blockOptions.Encoding)
+	}
+
+	// Walk only fails if the path doesn't exist, files of revisions and of
+	// the index don't need to exist on disk
+	if _, err := os.Stat(searchPath); err != nil && walker.Revision == "" && !walker.Staged {
+		return mcp.NewToolResultError(fmt.Sprintf("error searching: %v", err)), nil
+	}
+
+	search := func(file File) searchResult {
+		path := file.Path
+
+		result := searchResult{Path: path}
+
+		blocks, err := findFileBlocks(file, query, blockOptions)
+		if err != nil {
+			result.Err = err
+			return result
+		}
 
-	// Collect all formatted blocks
-	var results []string
+		if walker.Diff != nil {
+			blocks = walker.Diff.Filter(path, blocks)
+		}
 
-	err = walker.Walk(searchPath, func(path string) error {
-		blocks, err := findBlocks(path, query, higherThan)
+		blocks, err = filterBlocks(path, blocks, filters, mode)
 		if err != nil {
-			return nil // Skip files that can't be processed
+			result.Err = err
+			return result
 		}
 
-		blocks, err = filterBlocks(blocks, filters)
+		blocks, err = printBlocks(path, blocks, printer)
 		if err != nil {
-			return nil
+			result.Err = err
+			return result
 		}
 
+		result.Blocks = blocks
 		if len(blocks) == 0 {
-			return nil
+			return result
 		}
 
 		// Format blocks without colors (not useful for MCP), with line numbers, filename header
-		formatted := blocks.Format(false, path, true, false)
-		results = append(results, formatted...)
-		return nil
-	})
-	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("error searching: %v", err)), nil
+		result.Output = strings.Join(blocks.Format(false, path, true, false), "\n\n")
+		return result
 	}
 
+	// Collect all formatted blocks, files which failed are listed after
+	// them, so they aren't taken for files without matches
+	var (
+		results []string
+		skipped []string
+		found   = 0
+		limited = false
+	)
+
+	searchFiles(walker, []string{searchPath}, 0, search, func(result searchResult) bool {
+		if result.Err != nil {
+			skipped = append(skipped, result.Path+": "+result.Err.Error())
+			return true
+		}
+
+		if len(result.Blocks) == 0 {
+			return true
+		}
+
+		if maxBlocks > 0 && found+len(result.Blocks) > maxBlocks {
+			result.Blocks = result.Blocks[:maxBlocks-found]
+			result.Output = strings.Join(
+				result.Blocks.Format(false, result.Path, true, false),
+				"\n\n",
+			)
+		}
+
+		found += len(result.Blocks)
+		results = append(results, result.Output)
+
+		limited = maxBlocks > 0 && found >= maxBlocks
+		return !limited
+	})
+
 	if len(results) == 0 {
-		return mcp.NewToolResultText("No blocks found matching the query."), nil
+		results = append(results, "No blocks found matching the query.")
+	}
+
+	if limited {
+		results = append(results, fmt.Sprintf(
+			"Stopped after %d blocks, narrow down the query or raise max_blocks to see more.",
+			maxBlocks,
+		))
+	}
+
+	if len(skipped) > 0 {
+		results = append(results, fmt.Sprintf(
+			"Skipped %d file(s) because of errors:\n%s",
+			len(skipped),
+			strings.Join(skipped, "\n"),
+		))
 	}
 
 	return mcp.NewToolResultText(strings.Join(results, "\n\n")), nil
@@ -196,7 +537,7 @@ func (m *MCPServer) handleListFiles(ctx context.Context, request mcp.CallToolReq
 	}
 
 	// Create file walker
-	walker := NewFileWalker(".", extensions)
+	walker := NewFileWalker(extensions)
 
 	files, err := walker.ListFiles(searchPath)
 	if err != nil {
@@ -214,3 +555,28 @@ func (m *MCPServer) handleListFiles(ctx context.Context, request mcp.CallToolReq
 
 	return mcp.NewToolResultText(output), nil
 }
+
+// getStringArray converts array argument of a tool into strings, a single
+// string is treated as array of one item.
+func getStringArray(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		if value == "" {
+			return nil
+		}
+
+		return []string{value}
+
+	case []interface{}:
+		result := []string{}
+		for _, item := range value {
+			if item, ok := item.(string); ok && item != "" {
+				result = append(result, item)
+			}
+		}
+
+		return result
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMCPServer_SearchSkippedFiles(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	test.NoError(os.WriteFile(filepath.Join(dir, "a.go"), []byte("func foo() {\n}\n"), 0644))
+	test.NoError(os.WriteFile(
+		filepath.Join(dir, "b.go"),
+		[]byte("func bar() {\n\treturn\n}\n"),
+		0644,
+	))
+
+	previous, err := os.Getwd()
+	test.NoError(err)
+
+	t.Cleanup(func() {
+		os.Chdir(previous)
+	})
+
+	server, err := NewMCPServer(dir, Config{})
+	test.NoError(err)
+
+	search := func(args map[string]any) string {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+
+		result, err := server.handleSearchBlocks(context.Background(), request)
+		test.NoError(err)
+		test.False(result.IsError)
+		test.Len(result.Content, 1)
+
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	// files over the limit are reported, so they aren't taken for files
+	// without matches
+	test.Equal(
+		"a.go\n1:func foo() {\n2:}\n\n"+
+			"Skipped 1 file(s) because of errors:\n"+
+			"b.go: file is larger than the limit: 20 bytes",
+		search(map[string]any{"query": "^func", "max_filesize": "20"}),
+	)
+
+	test.Equal(
+		"No blocks found matching the query.\n\n"+
+			"Skipped 1 file(s) because of errors:\n"+
+			"b.go: file is larger than the limit: 20 bytes",
+		search(map[string]any{"query": "^func bar", "max_filesize": "20"}),
+	)
+
+	test.Equal(
+		"No blocks found matching the query.",
+		search(map[string]any{"query": "^func qux"}),
+	)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"regexp"
+	"regexp/syntax"
+)
+
+// getRequiredLiteral returns the longest literal string every match of the
+// query contains, files and lines without it can't match and are skipped
+// without running the query. Empty string means there is no such literal.
+func getRequiredLiteral(query *regexp.Regexp) string {
+	tree, err := syntax.Parse(query.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	return getNodeLiteral(tree.Simplify())
+}
+
+func getNodeLiteral(node *syntax.Regexp) string {
+	switch node.Op {
+	case syntax.OpLiteral:
+		if node.Flags&syntax.FoldCase != 0 {
+			return ""
+		}
+
+		return string(node.Rune)
+
+	case syntax.OpCapture:
+		return getNodeLiteral(node.Sub[0])
+
+	case syntax.OpPlus:
+		return getNodeLiteral(node.Sub[0])
+
+	case syntax.OpRepeat:
+		if node.Min == 0 {
+			return ""
+		}
+
+		return getNodeLiteral(node.Sub[0])
+
+	case syntax.OpConcat:
+		var (
+			longest = ""
+			run     = ""
+		)
+
+		for _, sub := range node.Sub {
+			switch {
+			case sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0:
+				run += string(sub.Rune)
+
+			case isZeroWidth(sub):
+				// assertions don't consume text, so the literals around
+				// them are adjacent in the match
+
+			default:
+				if literal := getNodeLiteral(sub); len(literal) > len(longest) {
+					longest = literal
+				}
+
+				run = ""
+			}
+
+			if len(run) > len(longest) {
+				longest = run
+			}
+		}
+
+		return longest
+	}
+
+	return ""
+}
+
+func isZeroWidth(node *syntax.Regexp) bool {
+	switch node.Op {
+	case syntax.OpEmptyMatch,
+		syntax.OpBeginLine,
+		syntax.OpEndLine,
+		syntax.OpBeginText,
+		syntax.OpEndText,
+		syntax.OpWordBoundary,
+		syntax.OpNoWordBoundary:
+		return true
+	}
+
+	return false
+}
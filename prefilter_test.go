@@ -0,0 +1,69 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRequiredLiteral(t *testing.T) {
+	test := assert.New(t)
+
+	testcases := []struct {
+		query    string
+		expected string
+	}{
+		{`panic`, "panic"},
+		{`func.*{`, "func"},
+		{`^func \w+Handler\(`, "Handler("},
+		{`\bctx\.Done\(\)`, "ctx.Done()"},
+		{`(?m)^\s*return nil$`, "return nil"},
+		{`(fo)+bar`, "bar"},
+		{`(foobar)+ba`, "foobar"},
+		{`x{2,}`, "x"},
+		{`(?:a|b)cd`, "cd"},
+		{`foo|bar`, ""},
+		{`(?i)panic`, ""},
+		{`\w+`, ""},
+		{`a*`, ""},
+	}
+
+	for _, testcase := range testcases {
+		test.Equal(
+			testcase.expected,
+			getRequiredLiteral(regexp.MustCompile(testcase.query)),
+			testcase.query,
+		)
+	}
+}
+
+func TestFindBlocks_Literal(t *testing.T) {
+	test := assert.New(t)
+
+	path := writeTestFile(t, "a.go", `package main
+
+func Foo() {
+	panic("unreachable")
+}
+
+func Bar() {
+	return
+}
+`)
+
+	for _, query := range []string{`panic\("unreachable"\)`, `^func \w+\(`, `missing`} {
+		query := regexp.MustCompile(query)
+
+		expected, err := findBlocks(path, query, BlockOptions{})
+		test.NoError(err)
+
+		blocks, err := findBlocks(
+			path,
+			query,
+			BlockOptions{Literal: getRequiredLiteral(query)},
+		)
+		test.NoError(err)
+		test.Equal(getLineRanges(expected), getLineRanges(blocks), query.String())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/reconquest/pkg/log"
+)
+
+// searchResult holds blocks found in a single file.
+type searchResult struct {
+	Path   string
+	Blocks Blocks
+
+	// Output is prepared by the worker, formatting and encoding blocks is
+	// as expensive as searching them.
+	Output string
+
+	Err error
+}
+
+// errSearchStopped stops walking when the collector doesn't need more
+// results.
+var errSearchStopped = errors.New("search stopped")
+
+// logSearchError logs the error of the result, files skipped by the size
+// limit are reported only in verbose mode.
+func logSearchError(result searchResult) {
+	if errors.Is(result.Err, errFileTooLarge) {
+		log.Debugf(nil, "skip %s: %s", result.Path, result.Err)
+		return
+	}
+
+	log.Errorf(result.Err, "%s", result.Path)
+}
+
+// searchFiles walks the given paths and searches found files concurrently
+// using the given number of workers, GOMAXPROCS if it's not positive.
+// Results are passed to collect in the walk order, so the output doesn't
+// depend on which file is searched faster. The search stops as soon as
+// collect returns false.
+func searchFiles(
+	walker *FileWalker,
+	paths []string,
+	jobs int,
+	search func(file File) searchResult,
+	collect func(result searchResult) bool,
+) {
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+
+	type task struct {
+		file   File
+		result chan searchResult
+	}
+
+	var (
+		tasks = make(chan task)
+		// queue holds pending results in the walk order, its capacity
+		// limits how far workers can get ahead of the collector
+		queue = make(chan chan searchResult, jobs*4)
+		// done is closed when the collector stops reading the queue
+		done = make(chan struct{})
+	)
+
+	defer close(done)
+
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for task := range tasks {
+				task.result <- search(task.file)
+			}
+		}()
+	}
+
+	enqueue := func(result chan searchResult) bool {
+		select {
+		case queue <- result:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(queue)
+		defer close(tasks)
+
+		for _, path := range paths {
+			log.Debug("stat: " + path)
+
+			err := walker.WalkFiles(path, func(file File) error {
+				result := make(chan searchResult, 1)
+				if !enqueue(result) {
+					return errSearchStopped
+				}
+
+				select {
+				case tasks <- task{file: file, result: result}:
+					return nil
+				case <-done:
+					return errSearchStopped
+				}
+			})
+			if err == errSearchStopped {
+				return
+			}
+
+			if err != nil {
+				result := make(chan searchResult, 1)
+				result <- searchResult{Path: path, Err: err}
+				if !enqueue(result) {
+					return
+				}
+			}
+		}
+	}()
+
+	for result := range queue {
+		if !collect(<-result) {
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchFiles_Order(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	expected := []string{}
+	for i := 0; i < 50; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%02d.txt", i))
+		test.NoError(os.WriteFile(path, []byte("a\n"), 0644))
+
+		expected = append(expected, path)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	expected = append(expected, missing)
+
+	actual := []string{}
+	searchFiles(
+		NewFileWalker(nil),
+		[]string{dir, missing},
+		8,
+		func(file File) searchResult {
+			// later files are searched faster
+			index := 0
+			fmt.Sscanf(filepath.Base(file.Path), "%d.txt", &index)
+			time.Sleep(time.Duration(50-index) * 20 * time.Microsecond)
+
+			return searchResult{Path: file.Path}
+		},
+		func(result searchResult) bool {
+			actual = append(actual, result.Path)
+			return true
+		},
+	)
+
+	test.Equal(expected, actual)
+}
+
+func TestSearchFiles_Stop(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+	for i := 0; i < 100; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%03d.txt", i))
+		test.NoError(os.WriteFile(path, []byte("x"), 0644))
+	}
+
+	actual := []string{}
+	searchFiles(
+		NewFileWalker(nil),
+		[]string{dir},
+		4,
+		func(file File) searchResult {
+			return searchResult{Path: file.Path}
+		},
+		func(result searchResult) bool {
+			actual = append(actual, filepath.Base(result.Path))
+			return len(actual) < 3
+		},
+	)
+
+	test.Equal([]string{"000.txt", "001.txt", "002.txt"}, actual)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// BlockStrategy decides where a block that starts at a matching line ends.
+// A new strategy is created for every file, so it may keep state between
+// Prepare and GetBlockEnd.
+type BlockStrategy interface {
+	// Name returns the name used to force the strategy from CLI and MCP.
+	Name() string
+
+	// Prepare is called once per file before searching for matches.
+	Prepare(lines []string)
+
+	// GetBlockEnd returns index of the last line of the block that starts at
+	// the given line.
+	GetBlockEnd(lines []string, start int) int
+
+	// GetLevel returns nesting level of the line, headers of blocks
+	// enclosing the line are the nearest lines above with lower levels.
+	GetLevel(lines []string, index int) int
+}
+
+// blockStrategies creates strategies by name, the searched file allows to
+// pick a language-specific flavor of the strategy.
+var blockStrategies = map[string]func(
+	filename string,
+	options BlockOptions,
+) BlockStrategy{
+	"indent": func(filename string, options BlockOptions) BlockStrategy {
+		return &IndentationStrategy{
+			HigherThan:          options.HigherThan,
+			Indentation:         getIndentation(filename, options),
+			SkipTerminatingLine: options.SkipTerminatingLine,
+		}
+	},
+	"brace": func(filename string, options BlockOptions) BlockStrategy {
+		syntax, ok := braceLanguages[getExtension(filename)]
+		if !ok {
+			syntax = cSyntax
+		}
+
+		return &BraceStrategy{Syntax: syntax}
+	},
+	"keyword": func(filename string, options BlockOptions) BlockStrategy {
+		syntax, ok := keywordLanguages[getExtension(filename)]
+		if !ok {
+			syntax = genericKeywords
+		}
+
+		return &KeywordStrategy{Syntax: syntax}
+	},
+	"tag": func(filename string, options BlockOptions) BlockStrategy {
+		return &TagStrategy{HTML: htmlLanguages[getExtension(filename)]}
+	},
+	"heading": func(filename string, options BlockOptions) BlockStrategy {
+		marker, ok := headingLanguages[getExtension(filename)]
+		if !ok {
+			marker = '#'
+		}
+
+		return &HeadingStrategy{Marker: marker}
+	},
+}
+
+// getBlockStrategyNames returns names of all known strategies including
+// "auto" which picks the strategy by file extension.
+func getBlockStrategyNames() []string {
+	return []string{"auto", "indent", "brace", "keyword", "tag", "heading"}
+}
+
+// checkBlockStrategy returns error if there is no strategy with given name.
+func checkBlockStrategy(name string) error {
+	if name == "" || name == "auto" {
+		return nil
+	}
+
+	if _, ok := blockStrategies[name]; !ok {
+		return fmt.Errorf(
+			"unknown block strategy: %q, expected one of: %s",
+			name,
+			strings.Join(getBlockStrategyNames(), ", "),
+		)
+	}
+
+	return nil
+}
+
+// getBlockStrategy returns the strategy forced by name or the one that suits
+// the file extension best if the name is empty or "auto".
+func getBlockStrategy(filename string, options BlockOptions) (BlockStrategy, error) {
+	err := checkBlockStrategy(options.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	name := options.Strategy
+	if name == "" || name == "auto" {
+		name = detectBlockStrategy(getExtension(filename))
+	}
+
+	return blockStrategies[name](filename, options), nil
+}
+
+func detectBlockStrategy(extension string) string {
+	if _, ok := braceLanguages[extension]; ok {
+		return "brace"
+	}
+
+	if _, ok := keywordLanguages[extension]; ok {
+		return "keyword"
+	}
+
+	if _, ok := tagLanguages[extension]; ok {
+		return "tag"
+	}
+
+	if _, ok := headingLanguages[extension]; ok {
+		return "heading"
+	}
+
+	return "indent"
+}
+
+func getExtension(filename string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+}
+
+// IndentationStrategy includes all following lines that are empty or indented
+// deeper than the matching line plus HigherThan levels.
+type IndentationStrategy struct {
+	HigherThan  int
+	Indentation Indentation
+
+	// SkipTerminatingLine leaves out the line terminating the block unless
+	// it's a closing line, see BlockOptions.SkipTerminatingLine.
+	SkipTerminatingLine bool
+}
+
+func (strategy *IndentationStrategy) Name() string {
+	return "indent"
+}
+
+func (strategy *IndentationStrategy) Prepare(lines []string) {
+	if strategy.Indentation.Size == 0 {
+		strategy.Indentation.Size = detectIndentationSize(
+			lines,
+			strategy.Indentation.TabWidth,
+		)
+	}
+}
+
+func (strategy *IndentationStrategy) GetBlockEnd(lines []string, start int) int {
+	tabWidth := strategy.Indentation.TabWidth
+
+	level := getIndentationLevel(lines[start], tabWidth) +
+		strategy.HigherThan*strategy.Indentation.Size
+	if level < 0 {
+		level = 0
+	}
+
+	end := start
+	for next := start + 1; next < len(lines); next++ {
+		if lines[next] == "" ||
+			getIndentationLevel(lines[next], tabWidth) > level {
+			end = next
+			continue
+		}
+
+		if isHeaderContinuation(lines[next]) {
+			start = next
+			end = next
+			continue
+		}
+
+		// the line at the same level terminates the block, like closing
+		// brace, so it's included as well
+		if end > start && isTerminatingLineIncluded(lines[next], strategy.SkipTerminatingLine) {
+			end = next
+		}
+
+		break
+	}
+
+	return end
+}
+
+func (strategy *IndentationStrategy) GetLevel(lines []string, index int) int {
+	return getIndentationLevel(lines[index], strategy.Indentation.TabWidth)
+}
+
+// isHeaderContinuation reports whether the line closes brackets of the
+// header above, like `):` of a wrapped signature, so the block goes on below
+// it instead of being terminated by the line.
+func isHeaderContinuation(line string) bool {
+	trimmed := strings.TrimLeft(line, " \t")
+
+	return isContinuationLine(trimmed) && !isClosingLine(trimmed)
+}
+
+// isTerminatingLineIncluded reports whether the line terminating the block
+// belongs to it, only closing lines do if skip is true.
+func isTerminatingLineIncluded(line string, skip bool) bool {
+	return !skip || isClosingLine(strings.TrimLeft(line, " \t"))
+}
+
+// nestingLine holds nesting depth at the beginning of the line, the lowest
+// and the highest depth reached within the line and depth at its end.
+type nestingLine struct {
+	start int
+	min   int
+	max   int
+	end   int
+	// tail is the last significant character of the line, comments excluded.
+	tail byte
+}
+
+// update tracks depth changes within the line.
+func (line *nestingLine) update(depth int) {
+	if depth > line.max {
+		line.max = depth
+	}
+
+	if depth < line.min {
+		line.min = depth
+	}
+}
+
+type nestingLines []nestingLine
+
+// getBlockEnd returns index of the last line of the block that starts at the
+// given line: the block lasts until everything opened in it is closed. The
+// continues function may prolong the block after it's closed.
+func (lines nestingLines) getBlockEnd(
+	text []string,
+	start int,
+	continues func(line nestingLine, next string) bool,
+) int {
+	base := lines[start].min
+
+	for index := start; index < len(lines); index++ {
+		if lines[index].end > base {
+			continue
+		}
+
+		if continues == nil {
+			return index
+		}
+
+		next := getNextNonEmptyLine(text, index+1)
+		if next < 0 || !continues(lines[index], text[next]) {
+			return index
+		}
+
+		index = next - 1
+	}
+
+	return len(lines) - 1
+}
+
+// getLevel returns the lowest depth reached within the line, so lines
+// like `} else {` are at the level of the line opening the block.
+func (lines nestingLines) getLevel(index int) int {
+	return lines[index].min
+}
+
+func getNextNonEmptyLine(lines []string, start int) int {
+	for index := start; index < len(lines); index++ {
+		if strings.TrimSpace(lines[index]) != "" {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func hasAnyPrefix(text string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
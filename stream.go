@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// streamingThreshold is the size of files which are read line by line
+// instead of reading them into memory at once.
+const streamingThreshold = 64 << 20
+
+// defaultMaxLineLength limits lines of streamed files, a file without line
+// breaks would be read into memory as a whole otherwise.
+const defaultMaxLineLength = 1 << 20
+
+// canStreamBlocks reports whether blocks of the file can be found reading it
+// line by line. Only the indent strategy finds the end of the block looking
+// at the following lines only, and climbing up, merging and nesting blocks
+// or multiline matches need lines before the current block.
+func canStreamBlocks(filename string, options BlockOptions) bool {
+	if options.Up != 0 || options.Multiline {
+		return false
+	}
+
+	if options.Overlap != "" && options.Overlap != OverlapSkip {
+		return false
+	}
+
+	strategy, err := getBlockStrategy(filename, options)
+	if err != nil {
+		return false
+	}
+
+	indentation, ok := strategy.(*IndentationStrategy)
+	if !ok {
+		return false
+	}
+
+	// indentation size is detected looking at the whole file
+	return indentation.HigherThan == 0 || indentation.Indentation.Size != 0
+}
+
+// lineReader splits the input into lines the same way as strings.Split does
+// with "\n" separator, so the input ending with a line break has an empty
+// last line.
+type lineReader struct {
+	reader    *bufio.Reader
+	maxLength int
+	number    int
+	done      bool
+}
+
+func newLineReader(reader io.Reader, maxLength int) *lineReader {
+	if maxLength <= 0 {
+		maxLength = defaultMaxLineLength
+	}
+
+	return &lineReader{
+		reader:    bufio.NewReader(reader),
+		maxLength: maxLength,
+	}
+}
+
+// Next returns the next line, io.EOF is returned after the last line.
+func (reader *lineReader) Next() (string, error) {
+	if reader.done {
+		return "", io.EOF
+	}
+
+	reader.number++
+
+	var line []byte
+	for {
+		chunk, err := reader.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > reader.maxLength+1 {
+			return "", fmt.Errorf(
+				"line %d is longer than %d bytes",
+				reader.number,
+				reader.maxLength,
+			)
+		}
+
+		line = append(line, chunk...)
+
+		switch err {
+		case nil:
+			return string(line[:len(line)-1]), nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			reader.done = true
+			return string(line), nil
+		default:
+			return "", err
+		}
+	}
+}
+
+// streamedBlock is the block which is being read.
+type streamedBlock struct {
+	Block
+
+	// start is index of the matching line and level is the indentation the
+	// following lines should exceed to belong to the block
+	start int
+	level int
+
+	// end is index of the last line of the block including truncated ones
+	end int
+
+	// matched holds Contains and NotContains patterns matching truncated
+	// lines, the block is filtered as a whole without keeping them
+	matched map[*regexp.Regexp]bool
+}
+
+// add appends the line to the block unless the block has MaxBlockLines
+// lines already, then the line and its matches are only counted as
+// truncated.
+func (block *streamedBlock) add(
+	line string,
+	index int,
+	matches []BlockMatch,
+	options BlockOptions,
+) {
+	block.end = index
+
+	if options.MaxBlockLines > 0 && len(block.Lines) >= options.MaxBlockLines {
+		block.Truncated++
+		block.matchTruncated(line, options)
+		return
+	}
+
+	block.Lines = append(block.Lines, BlockLine{Line: index + 1, Text: line})
+	block.Matches = append(block.Matches, matches...)
+}
+
+// truncate is like Block.truncate, but dropped lines are checked against
+// Contains and NotContains patterns first.
+func (block *streamedBlock) truncate(options BlockOptions) {
+	max := options.MaxBlockLines
+	if max > 0 && len(block.Lines) > max {
+		for _, line := range block.Lines[max:] {
+			block.matchTruncated(line.Text, options)
+		}
+	}
+
+	block.Block.truncate(max)
+}
+
+// matchTruncated remembers patterns matching the truncated line, patterns
+// spanning several lines are not matched across the cut.
+func (block *streamedBlock) matchTruncated(line string, options BlockOptions) {
+	for _, patterns := range [][]*regexp.Regexp{options.Contains, options.NotContains} {
+		for _, pattern := range patterns {
+			if block.matched[pattern] || !pattern.MatchString(line) {
+				continue
+			}
+
+			if block.matched == nil {
+				block.matched = map[*regexp.Regexp]bool{}
+			}
+
+			block.matched[pattern] = true
+		}
+	}
+}
+
+// streamBlocks finds the same blocks as extractBlocks with the indent
+// strategy does, but keeps only the current block in memory.
+func streamBlocks(
+	filename string,
+	input io.Reader,
+	query *regexp.Regexp,
+	options BlockOptions,
+) (Blocks, error) {
+	strategy, err := getBlockStrategy(filename, options)
+	if err != nil {
+		return nil, err
+	}
+
+	indentation := strategy.(*IndentationStrategy).Indentation
+
+	var (
+		reader   = newLineReader(input, options.MaxLineLength)
+		prefixes = getLeadingPrefixes(filename)
+		tabWidth = indentation.TabWidth
+		literal  = options.Literal
+
+		result = Blocks{}
+
+		// ancestors holds the last lines of every indentation level which
+		// aren't followed by less indented lines, so they enclose the
+		// current line
+		ancestors = []ancestor{}
+
+		// docs holds comments right above the current line which don't
+		// belong to the previous block
+		docs = []BlockLine{}
+
+		current *streamedBlock
+	)
+
+	getMatches := func(line string, index int) []BlockMatch {
+		if !strings.Contains(line, literal) {
+			return nil
+		}
+
+		return findLineMatches(query, line, index+1)
+	}
+
+	finish := func() {
+		if options.matchContents(current.JoinLines(), current.matched) {
+			result = append(result, current.Block)
+		}
+
+		current = nil
+		docs = docs[:0]
+	}
+
+	for index := 0; ; index++ {
+		line, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		lineLevel := getIndentationLevel(line, tabWidth)
+
+		if current != nil {
+			consumed := true
+			switch {
+			case line == "" || lineLevel > current.level:
+				current.add(
+					line,
+					index,
+					getMatches(line, index),
+					options,
+				)
+
+			case isHeaderContinuation(line):
+				current.add(
+					line,
+					index,
+					getMatches(line, index),
+					options,
+				)
+
+				current.start = index
+
+			case current.end > current.start &&
+				isTerminatingLineIncluded(line, options.SkipTerminatingLine):
+				// the line at the same level terminates the block, like
+				// closing brace, so it's included as well
+				current.add(
+					line,
+					index,
+					getMatches(line, index),
+					options,
+				)
+
+				finish()
+
+			default:
+				finish()
+				consumed = false
+			}
+
+			if consumed {
+				updateAncestors(&ancestors, line, index, lineLevel, prefixes)
+				continue
+			}
+		}
+
+		matches := getMatches(line, index)
+		if len(matches) > 0 {
+			current = &streamedBlock{
+				start: index,
+				level: lineLevel + options.HigherThan*indentation.Size,
+				end:   index,
+			}
+
+			if current.level < 0 {
+				current.level = 0
+			}
+
+			current.Ancestors = getAncestors(ancestors, lineLevel)
+			current.Matches = []BlockMatch{}
+
+			if options.IncludeDocs {
+				current.Lines = getStreamedDocs(docs, line, prefixes, tabWidth)
+
+				// documentation is truncated as a part of the block
+				current.truncate(options)
+			}
+
+			current.add(line, index, matches, options)
+		} else if options.IncludeDocs {
+			trimmed := strings.TrimLeft(line, " \t")
+			if trimmed != "" && hasAnyPrefix(trimmed, prefixes) {
+				docs = append(docs, BlockLine{Line: index + 1, Text: line})
+			} else {
+				docs = docs[:0]
+			}
+		}
+
+		updateAncestors(&ancestors, line, index, lineLevel, prefixes)
+	}
+
+	if current != nil {
+		finish()
+	}
+
+	return result, nil
+}
+
+// getStreamedDocs returns comments and decorators documenting the line the
+// same way as getLeadingLineStart does.
+func getStreamedDocs(
+	docs []BlockLine,
+	line string,
+	prefixes []string,
+	tabWidth int,
+) []BlockLine {
+	lines := make([]string, 0, len(docs)+1)
+	for _, doc := range docs {
+		lines = append(lines, doc.Text)
+	}
+
+	lines = append(lines, line)
+
+	begin := getLeadingLineStart(lines, len(docs), 0, prefixes, tabWidth)
+
+	return append([]BlockLine{}, docs[begin:]...)
+}
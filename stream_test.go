@@ -0,0 +1,118 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamBlocks_SameAsExtractBlocks(t *testing.T) {
+	test := assert.New(t)
+
+	contents := `import os
+
+# leading comment
+@decorator
+def foo():
+    if x:
+        return foo
+
+    # inner comment
+    for item in items:
+        print(item)
+        foo()
+    pass
+
+class Server:
+    def run(self):
+        foo = 1
+    foo = 2
+
+foo
+bar
+  foo
+    nested foo
+  end
+baz
+
+def wrapped(
+    foo,
+):
+    return foo
+
+bar = 1`
+
+	queries := []string{`foo`, `^\s*def `, `item`, `^baz`, `nothing`, `wrapped`}
+
+	optionsList := []BlockOptions{
+		{},
+		{IncludeDocs: true},
+		{TabWidth: 4},
+		{Contains: []*regexp.Regexp{regexp.MustCompile(`return`)}},
+		{MaxBlockLines: 2},
+		{SkipTerminatingLine: true},
+		{MaxBlockLines: 1, IncludeDocs: true},
+		{MaxBlockLines: 3, Contains: []*regexp.Regexp{regexp.MustCompile(`for`)}},
+		{MaxBlockLines: 2, NotContains: []*regexp.Regexp{regexp.MustCompile(`print`)}},
+		{
+			MaxBlockLines: 1,
+			IncludeDocs:   true,
+			Contains:      []*regexp.Regexp{regexp.MustCompile(`@decorator`)},
+		},
+	}
+
+	for _, text := range []string{contents, contents + "\n", contents + "\n\n"} {
+		for _, query := range queries {
+			for _, options := range optionsList {
+				query := regexp.MustCompile(query)
+
+				expected, err := extractBlocks("a.py", text, query, options)
+				test.NoError(err)
+
+				test.True(canStreamBlocks("a.py", options))
+
+				actual, err := streamBlocks(
+					"a.py",
+					strings.NewReader(text),
+					query,
+					options,
+				)
+				test.NoError(err)
+				test.Equal(expected, actual, "query %s, options %+v", query, options)
+			}
+		}
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	test := assert.New(t)
+
+	long := strings.Repeat("x", 10000)
+
+	for _, text := range []string{"", "a", "a\n", "a\nbb\n\nccc", "\n\n", long + "\n" + long} {
+		reader := newLineReader(strings.NewReader(text), 0)
+
+		lines := []string{}
+		for {
+			line, err := reader.Next()
+			if err != nil {
+				break
+			}
+
+			lines = append(lines, line)
+		}
+
+		test.Equal(strings.Split(text, "\n"), lines, "%q", text)
+	}
+
+	reader := newLineReader(strings.NewReader("abc\nabcd\n"), 3)
+
+	line, err := reader.Next()
+	test.NoError(err)
+	test.Equal("abc", line)
+
+	_, err = reader.Next()
+	test.EqualError(err, "line 2 is longer than 3 bytes")
+}